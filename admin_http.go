@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// silenceRequest body to create a silence, duration is used when ends at is not given
+type silenceRequest struct {
+	Silence
+	Duration Duration `json:"duration"`
+}
+
+// AdminServer HTTP admin API, anyone who reaches it can mute alarms, so it must listen on localhost,
+// or requests except /ping must carry the token as Authorization: Bearer <token>
+//
+//	GET    /ping
+//	GET    /silences[?expired=true]
+//	POST   /silences        {"matchers":[{"name":"nodeName","value":"game1"}],"duration":"2h","createdBy":"","comment":""}
+//	DELETE /silences/<id>   expire the silence
+type AdminServer struct {
+	silences *SilenceStore
+	token    string
+	server   *http.Server
+}
+
+// NewAdminServer create AdminServer listening on the address, no token is required if token is empty
+func NewAdminServer(addr, token string, silences *SilenceStore) *AdminServer {
+	admin := &AdminServer{silences: silences, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", admin.handlePing)
+	mux.HandleFunc("/silences", admin.authorize(admin.handleSilences))
+	mux.HandleFunc("/silences/", admin.authorize(admin.handleSilence))
+	admin.server = &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	return admin
+}
+
+// start listen and serve in background, it refuses to listen on other than localhost without token
+func (admin *AdminServer) start() error {
+	listener, err := net.Listen("tcp", admin.server.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s fail: %v", admin.server.Addr, err)
+	}
+
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && !tcpAddr.IP.IsLoopback() && admin.token == "" {
+		_ = listener.Close()
+		return fmt.Errorf("listen %s without --http-token, anyone who reaches it can mute alarms, "+
+			"bind it to localhost or set --http-token", listener.Addr())
+	}
+
+	log.Printf("AdminServer listening on %s", listener.Addr())
+	go func() {
+		err := admin.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("AdminServer serve fail: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Close stop serving
+func (admin *AdminServer) Close() {
+	if admin == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = admin.server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// silenceErrorStatus not found or internal error
+func silenceErrorStatus(err error) int {
+	if errors.Is(err, errSilenceNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// authorize reject requests without the token
+func (admin *AdminServer) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if admin.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")),
+			[]byte("Bearer "+admin.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("token is required"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (admin *AdminServer) handlePing(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("OK"))
+}
+
+func (admin *AdminServer) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		silences, err := admin.silences.list(r.URL.Query().Get("expired") == "true")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, silences)
+	case http.MethodPost:
+		var req silenceRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		silence := req.Silence
+		if silence.EndsAt.IsZero() && req.Duration > 0 {
+			if silence.StartsAt.IsZero() {
+				silence.StartsAt = time.Now()
+			}
+			silence.EndsAt = silence.StartsAt.Add(time.Duration(req.Duration))
+		}
+
+		created, err := admin.silences.add(&silence)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("AdminServer silence %s added by %s: %v until %s", created.ID, created.CreatedBy,
+			created.Matchers, created.EndsAt.Format(time.RFC3339))
+		writeJSON(w, http.StatusOK, created)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (admin *AdminServer) handleSilence(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/silences/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, errSilenceNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		silence, err := admin.silences.get(id)
+		if err != nil {
+			writeError(w, silenceErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, silence)
+	case http.MethodDelete:
+		silence, err := admin.silences.expire(id)
+		if err != nil {
+			writeError(w, silenceErrorStatus(err), err)
+			return
+		}
+		log.Printf("AdminServer silence %s expired", id)
+		writeJSON(w, http.StatusOK, silence)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminServerToken(t *testing.T) {
+	tests := []struct {
+		token         string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"", "/silences/a/b", "", http.StatusNotFound},
+		{"secret", "/ping", "", http.StatusOK},
+		{"secret", "/silences", "", http.StatusUnauthorized},
+		{"secret", "/silences/a/b", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "/silences/a/b", "secret", http.StatusUnauthorized},
+		{"secret", "/silences/a/b", "Bearer secret", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		admin := NewAdminServer("127.0.0.1:0", test.token, nil)
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		admin.server.Handler.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("token %q %s %q: status %d, want %d", test.token, test.path, test.authorization, w.Code, test.status)
+		}
+	}
+}
+
+func TestAdminServerStartRequiresTokenOffLocalhost(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"127.0.0.1:0", "", true},
+		{"0.0.0.0:0", "", false},
+		{":0", "", false},
+		{"0.0.0.0:0", "secret", true},
+	}
+
+	for _, test := range tests {
+		admin := NewAdminServer(test.addr, test.token, nil)
+		err := admin.start()
+		if test.ok != (err == nil) {
+			t.Errorf("%s token %q: start error %v", test.addr, test.token, err)
+		}
+		if err == nil {
+			admin.Close()
+		}
+	}
+}
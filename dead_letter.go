@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// reasons of dead letters
+const (
+	DeadLetterParseError    = "parse-error"
+	DeadLetterInvalid       = "invalid-message"
+	DeadLetterHandleError   = "handle-error"
+	DeadLetterMaxAttempts   = "max-attempts"
+	DeadLetterDeliveryError = "delivery-error"
+	DeadLetterDropped       = "dropped"
+	DeadLetterRenderError   = "render-error"
+)
+
+// DeadLetterInfo message published to dead letter topic
+type DeadLetterInfo struct {
+	Reason    string    `json:"reason"`
+	Error     string    `json:"error"`
+	Topic     string    `json:"topic"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"messageId"`
+	Attempts  uint16    `json:"attempts"`
+	Timestamp time.Time `json:"timestamp"`
+	Body      string    `json:"body"`
+}
+
+// deadLetterError error which gives up the message to dead letter topic at once
+type deadLetterError struct {
+	reason string
+	err    error
+}
+
+func (e *deadLetterError) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason, e.err)
+}
+
+func (e *deadLetterError) Unwrap() error {
+	return e.err
+}
+
+// Retryable implement of retryableError
+func (e *deadLetterError) Retryable() bool {
+	return false
+}
+
+// deadLetterReason reason of the error which gives up the message
+func deadLetterReason(err error) string {
+	var dlErr *deadLetterError
+	if errors.As(err, &dlErr) {
+		return dlErr.reason
+	}
+
+	if !isRetryable(err) {
+		return DeadLetterHandleError
+	}
+
+	return DeadLetterMaxAttempts
+}
+
+// DeadLetterProducer publish messages which can not be parsed or delivered to dead letter topic,
+// nil DeadLetterProducer drops them
+type DeadLetterProducer struct {
+	topic    string
+	channel  string
+	producer *nsq.Producer
+}
+
+// NewDeadLetterProducer create DeadLetterProducer, nsqd address defaults to the first nsqd-tcp-address of config
+func NewDeadLetterProducer(opts *Options, cfg *nsq.Config, config *NsqToDingDingConfig) (*DeadLetterProducer, error) {
+	addr := opts.DeadLetterNsqdTCPAddress
+	if addr == "" {
+		if len(config.NsqdTCPAddresses) == 0 {
+			return nil, fmt.Errorf("--dead-letter-nsqd-tcp-address is required when nsqd-tcp-addresses is not configured")
+		}
+		addr = config.NsqdTCPAddresses[0]
+	}
+
+	producer, err := nsq.NewProducer(addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = producer.Ping()
+	if err != nil {
+		producer.Stop()
+		return nil, fmt.Errorf("dead letter nsqd %s unavailable: %v", addr, err)
+	}
+
+	return &DeadLetterProducer{
+		topic:    opts.DeadLetterTopic,
+		channel:  opts.Channel,
+		producer: producer,
+	}, nil
+}
+
+// publish publish the message with why it is given up
+func (dl *DeadLetterProducer) publish(reason, topic string, m *nsq.Message, cause error) {
+	if dl == nil || m == nil {
+		return
+	}
+
+	info := DeadLetterInfo{
+		Reason:    reason,
+		Topic:     topic,
+		Channel:   dl.channel,
+		MessageID: string(m.ID[:]),
+		Attempts:  m.Attempts,
+		Timestamp: time.Now(),
+		Body:      string(m.Body),
+	}
+	if cause != nil {
+		info.Error = cause.Error()
+	}
+
+	body, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("DeadLetterProducer marshal fail: %v", err)
+		return
+	}
+
+	err = dl.producer.Publish(dl.topic, body)
+	if err != nil {
+		log.Printf("DeadLetterProducer publish to %s fail: %v %s", dl.topic, err, string(body))
+	}
+}
+
+// Stop stop the producer
+func (dl *DeadLetterProducer) Stop() {
+	if dl == nil {
+		return
+	}
+
+	dl.producer.Stop()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DedupConfig send the first alarm of a fingerprint at once, suppress the repeated ones within window
+// and send a summary of them when the window ends
+type DedupConfig struct {
+	Fields []string `json:"fields"` // fields making up fingerprint, message is normalized
+	Window Duration `json:"window"`
+}
+
+var defaultDedupFields = []string{FieldNodeName, FieldFileName, FieldMessage}
+
+func (config *DedupConfig) compile() error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Window <= 0 {
+		return fmt.Errorf("dedup window should be positive")
+	}
+	if len(config.Fields) == 0 {
+		config.Fields = defaultDedupFields
+	}
+
+	return nil
+}
+
+var (
+	normalizeAddrRegexp   = regexp.MustCompile(`0[xX][0-9a-fA-F]+`)
+	normalizeUUIDRegexp   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	normalizeNumberRegexp = regexp.MustCompile(`\d+(\.\d+)?`)
+)
+
+// normalizeMessage replace addresses, uuids and numbers which vary between the same errors
+func normalizeMessage(msg string) string {
+	msg = normalizeAddrRegexp.ReplaceAllString(msg, "<addr>")
+	msg = normalizeUUIDRegexp.ReplaceAllString(msg, "<uuid>")
+	return normalizeNumberRegexp.ReplaceAllString(msg, "<n>")
+}
+
+// fingerprint fingerprint of the log made up of the fields
+func fingerprint(fields []string, logData LogDataInfo) string {
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value := logData.Fields[field]
+		if field == FieldMessage {
+			value = normalizeMessage(logData.Msg)
+		}
+		values = append(values, value)
+	}
+
+	sum := sha1.Sum([]byte(strings.Join(values, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+type dedupEntry struct {
+	route    string
+	m        *nsq.Message
+	logData  LogDataInfo
+	first    time.Time
+	window   time.Duration
+	count    int
+	machines map[string]struct{}
+	timer    *time.Timer
+}
+
+// Deduplicator suppress repeated alarms by fingerprint
+type Deduplicator struct {
+	mutex   sync.Mutex
+	entries map[string]*dedupEntry
+	summary func(route string, m *nsq.Message, logData LogDataInfo, content string)
+}
+
+// NewDeduplicator create Deduplicator, summary is called when repeated alarms are suppressed in a window
+func NewDeduplicator(summary func(route string, m *nsq.Message, logData LogDataInfo, content string)) *Deduplicator {
+	return &Deduplicator{
+		entries: make(map[string]*dedupEntry),
+		summary: summary,
+	}
+}
+
+// admit whether the alarm should be sent by the route at now, false if it repeats one sent within window,
+// routes deduplicate apart
+func (dedup *Deduplicator) admit(config *DedupConfig, route string, m *nsq.Message, logData LogDataInfo,
+	now time.Time) bool {
+	if config == nil {
+		return true
+	}
+
+	key := route + "\x00" + fingerprint(config.Fields, logData)
+
+	dedup.mutex.Lock()
+	defer dedup.mutex.Unlock()
+
+	if entry, ok := dedup.entries[key]; ok {
+		entry.count++
+		entry.machines[logData.MachineName] = struct{}{}
+		return false
+	}
+
+	entry := &dedupEntry{
+		route:    route,
+		m:        m,
+		logData:  logData,
+		first:    now,
+		window:   time.Duration(config.Window),
+		machines: map[string]struct{}{logData.MachineName: {}},
+	}
+	entry.timer = time.AfterFunc(entry.window, func() {
+		dedup.flush(key)
+	})
+	dedup.entries[key] = entry
+
+	return true
+}
+
+// flush end the window of the fingerprint, and send summary if any is suppressed
+func (dedup *Deduplicator) flush(key string) {
+	dedup.mutex.Lock()
+	entry, ok := dedup.entries[key]
+	delete(dedup.entries, key)
+	dedup.mutex.Unlock()
+
+	if !ok || entry.count == 0 {
+		return
+	}
+
+	content := fmt.Sprintf("%s\n自 %s 起 %s 内重复 %d 次, 涉及 %d 台机器", entry.logData.Msg,
+		entry.first.Format("2006-01-02 15:04:05"), entry.window, entry.count, len(entry.machines))
+	dedup.summary(entry.route, entry.m, entry.logData, content)
+}
+
+// Close end all windows at once
+func (dedup *Deduplicator) Close() {
+	dedup.mutex.Lock()
+	keys := make([]string, 0, len(dedup.entries))
+	for key, entry := range dedup.entries {
+		entry.timer.Stop()
+		keys = append(keys, key)
+	}
+	dedup.mutex.Unlock()
+
+	for _, key := range keys {
+		dedup.flush(key)
+	}
+}
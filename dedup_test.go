@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+func TestNormalizeMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"attempt to index nil value", "attempt to index nil value"},
+		{"player 10086 timeout after 3.5s", "player <n> timeout after <n>s"},
+		{"userdata: 0x7f3a2c001230 freed", "userdata: <addr> freed"},
+		{"session 123e4567-e89b-12d3-a456-426614174000 lost", "session <uuid> lost"},
+	}
+
+	for _, test := range tests {
+		if got := normalizeMessage(test.msg); got != test.want {
+			t.Errorf("%q: %q, want %q", test.msg, got, test.want)
+		}
+	}
+}
+
+func TestDeduplicatorAdmit(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+	config := &DedupConfig{Window: Duration(time.Hour)}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	type alarm struct {
+		route   string
+		node    string
+		machine string
+		msg     string
+		admit   bool
+	}
+	tests := []struct {
+		name      string
+		alarms    []alarm
+		summaries []string // sorted
+	}{
+		{"once", []alarm{{"r", "a", "m1", "error 1", true}}, nil},
+		{"repeated", []alarm{{"r", "a", "m1", "error 1", true}, {"r", "a", "m1", "error 2", false},
+			{"r", "a", "m2", "error 3", false}},
+			[]string{"error 1\n自 2021-01-04 10:00:00 起 1h0m0s 内重复 2 次, 涉及 2 台机器"}},
+		{"nodes apart", []alarm{{"r", "a", "m1", "error 1", true}, {"r", "b", "m1", "error 1", true}}, nil},
+		{"routes apart", []alarm{{"r", "a", "m1", "error 1", true}, {"s", "a", "m1", "error 1", true},
+			{"s", "a", "m1", "error 1", false}},
+			[]string{"error 1\n自 2021-01-04 10:01:00 起 1h0m0s 内重复 1 次, 涉及 1 台机器"}},
+		{"other msg", []alarm{{"r", "a", "m1", "error 1", true}, {"r", "a", "m1", "timeout 1", true}}, nil},
+	}
+
+	for _, test := range tests {
+		var summaries []string
+		dedup := NewDeduplicator(func(route string, m *nsq.Message, logData LogDataInfo, content string) {
+			summaries = append(summaries, content)
+		})
+
+		for i, alarm := range test.alarms {
+			logData := LogDataInfo{MachineName: alarm.machine, Msg: alarm.msg,
+				Fields: map[string]string{FieldNodeName: alarm.node, FieldFileName: "file"}}
+			at := start.Add(time.Duration(i) * time.Minute)
+			if got := dedup.admit(config, alarm.route, nil, logData, at); got != alarm.admit {
+				t.Errorf("%s: alarm %d admitted %v, want %v", test.name, i+1, got, alarm.admit)
+			}
+		}
+		dedup.Close()
+
+		sort.Strings(summaries)
+		if strings.Join(summaries, ",") != strings.Join(test.summaries, ",") {
+			t.Errorf("%s: summaries %v, want %v", test.name, summaries, test.summaries)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DigestConfig alarms of digest topics are collected and sent as one summary every interval
+type DigestConfig struct {
+	Topics   []string `json:"topics"`
+	Samples  int      `json:"samples"`  // number of sample messages in summary
+	Top      int      `json:"top"`      // number of top nodes, files and error signatures in summary
+	Interval Duration `json:"interval"` // sync interval if not positive, routes may override it by digestInterval
+}
+
+const (
+	defaultDigestSamples = 3
+	defaultDigestTop     = 5
+	maxSignatureRunes    = 120
+	maxSampleRunes       = 500
+	// digestTick how often collected alarms are checked for summary, the finest interval of them
+	digestTick = time.Second
+)
+
+func (config *DigestConfig) compile() error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Samples < 0 || config.Top < 0 || config.Interval < 0 {
+		return fmt.Errorf("digest samples, top and interval should not be negative")
+	}
+	if config.Samples == 0 {
+		config.Samples = defaultDigestSamples
+	}
+	if config.Top == 0 {
+		config.Top = defaultDigestTop
+	}
+
+	return nil
+}
+
+// hasTopic whether alarms of the topic go to digest
+func (config *DigestConfig) hasTopic(topic string) bool {
+	if config == nil {
+		return false
+	}
+
+	for _, digestTopic := range config.Topics {
+		if digestTopic == topic {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signature error signature of msg, which varying numbers and addresses are normalized away
+func signature(msg string) string {
+	sig := normalizeMessage(msg)
+	if index := strings.IndexByte(sig, '\n'); index >= 0 {
+		sig = sig[:index]
+	}
+
+	return truncateRunes(maxSignatureRunes, sig)
+}
+
+type digestCount struct {
+	name  string
+	count int
+}
+
+// topCounts the top n counts, most first
+func topCounts(counts map[string]int, n int) []digestCount {
+	result := make([]digestCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, digestCount{name, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].count != result[j].count {
+			return result[i].count > result[j].count
+		}
+		return result[i].name < result[j].name
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+
+	return result
+}
+
+// digestState collected alarms of a route, or of a route within a time window
+type digestState struct {
+	route      string
+	window     *TimeWindowConfig // summary is sent when the window ends, every interval if nil
+	interval   time.Duration
+	top        int
+	m          *nsq.Message // the last message, which goes to dead letter topic if summary fails
+	since      time.Time
+	total      int
+	nodes      map[string]int
+	files      map[string]int
+	signatures map[string]int
+	samples    []LogDataInfo
+}
+
+func newDigestState(route string, window *TimeWindowConfig, interval time.Duration) *digestState {
+	return &digestState{
+		route:      route,
+		window:     window,
+		interval:   interval,
+		since:      time.Now(),
+		nodes:      make(map[string]int),
+		files:      make(map[string]int),
+		signatures: make(map[string]int),
+	}
+}
+
+// Digester collect alarms of a topic and summarize them of every route every digest interval of it
+type Digester struct {
+	topic    string
+	interval time.Duration // default digest interval
+	mutex    sync.Mutex
+	states   map[string]*digestState // key is route name, and time window name if any
+
+	summary  func(route string, m *nsq.Message, logData LogDataInfo, content string)
+	exitChan chan bool
+	wg       sync.WaitGroup
+}
+
+// NewDigester create Digester and start to summarize, interval is the default of routes without digest interval
+func NewDigester(topic string, interval time.Duration,
+	summary func(route string, m *nsq.Message, logData LogDataInfo, content string)) *Digester {
+	digester := &Digester{
+		topic:    topic,
+		interval: interval,
+		states:   make(map[string]*digestState),
+		summary:  summary,
+		exitChan: make(chan bool),
+	}
+
+	digester.wg.Add(1)
+	go digester.loop(digestTick)
+
+	return digester
+}
+
+// add collect the alarm of the route
+func (digester *Digester) add(config *DigestConfig, route string, m *nsq.Message, logData LogDataInfo) {
+	digester.collect(route, route, nil, config, m, logData)
+}
+
+// deferUntilEnd collect the alarm of the route within the time window, which is summarized when the window ends
+func (digester *Digester) deferUntilEnd(config *DigestConfig, route string, window *TimeWindowConfig, m *nsq.Message,
+	logData LogDataInfo) {
+	digester.collect(route+"\x00"+window.Name, route, window, config, m, logData)
+}
+
+func (digester *Digester) collect(key, route string, window *TimeWindowConfig, config *DigestConfig, m *nsq.Message,
+	logData LogDataInfo) {
+	if config == nil {
+		config = &DigestConfig{Samples: defaultDigestSamples, Top: defaultDigestTop}
+	}
+
+	digester.mutex.Lock()
+	defer digester.mutex.Unlock()
+
+	state, ok := digester.states[key]
+	if !ok {
+		interval := time.Duration(config.Interval)
+		if interval <= 0 {
+			interval = digester.interval
+		}
+		state = newDigestState(route, window, interval)
+		digester.states[key] = state
+	}
+
+	state.top = config.Top
+	state.m = m
+	state.total++
+	state.nodes[logData.NodeName]++
+	state.files[logData.FileName]++
+	state.signatures[signature(logData.Msg)]++
+	if len(state.samples) < config.Samples {
+		state.samples = append(state.samples, logData)
+	}
+}
+
+func (digester *Digester) loop(interval time.Duration) {
+	defer digester.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			digester.flush(false)
+		case <-digester.exitChan:
+			digester.flush(true)
+			return
+		}
+	}
+}
+
+// flush send summary of collected alarms of every route whose interval is over,
+// those of active time windows wait till the windows end, unless all
+func (digester *Digester) flush(all bool) {
+	now := time.Now()
+	var states []*digestState
+
+	digester.mutex.Lock()
+	for key, state := range digester.states {
+		if !all && state.window != nil && state.window.contains(now) {
+			continue
+		}
+		if !all && state.window == nil && now.Sub(state.since) < state.interval {
+			continue
+		}
+		states = append(states, state)
+		delete(digester.states, key)
+	}
+	digester.mutex.Unlock()
+
+	for _, state := range states {
+		m, logData, content := digester.summarize(state)
+		digester.summary(state.route, m, logData, content)
+	}
+}
+
+// summarize summary content of the state, with the last message and the first sample
+func (digester *Digester) summarize(state *digestState) (*nsq.Message, LogDataInfo, string) {
+	title := "[汇总]"
+	if state.window != nil {
+		title = fmt.Sprintf("[汇总 %s]", state.window.Name)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%s %s: 自 %s 起共 %d 条报警\n", title, digester.topic,
+		state.since.Format("2006-01-02 15:04:05"), state.total))
+	writeCounts := func(title string, counts map[string]int) {
+		builder.WriteString(title + ":\n")
+		for _, item := range topCounts(counts, state.top) {
+			builder.WriteString(fmt.Sprintf("  %d  %s\n", item.count, item.name))
+		}
+	}
+	writeCounts("节点", state.nodes)
+	writeCounts("文件", state.files)
+	writeCounts("主要错误", state.signatures)
+	builder.WriteString("样例:\n")
+	for _, sample := range state.samples {
+		builder.WriteString(fmt.Sprintf("- [%s %s] %s\n", sample.NodeName, sample.FileName,
+			truncateRunes(maxSampleRunes, sample.Msg)))
+	}
+
+	logData := LogDataInfo{}
+	if len(state.samples) > 0 {
+		logData = state.samples[0]
+	}
+
+	return state.m, logData, strings.TrimRight(builder.String(), "\n")
+}
+
+// Close send the last summary and stop
+func (digester *Digester) Close() {
+	close(digester.exitChan)
+	digester.wg.Wait()
+}
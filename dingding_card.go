@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// schemas of dingding msg
+const (
+	SchemaText       = "text"
+	SchemaMarkdown   = "markdown"
+	SchemaActionCard = "actionCard"
+	SchemaFeedCard   = "feedCard"
+	SchemaLink       = "link"
+)
+
+func checkSchema(schema string) error {
+	switch schema {
+	case "", SchemaText, SchemaMarkdown, SchemaActionCard, SchemaFeedCard, SchemaLink:
+		return nil
+	}
+
+	return fmt.Errorf("unknown schema %s", schema)
+}
+
+// DingDingReqActionCardBtn dingding req actionCard button structure
+type DingDingReqActionCardBtn struct {
+	Title     string `json:"title"`
+	ActionURL string `json:"actionURL"`
+}
+
+// DingDingReqActionCard dingding req actionCard schema structure
+type DingDingReqActionCard struct {
+	Title          string                     `json:"title"`
+	Text           string                     `json:"text"`
+	BtnOrientation string                     `json:"btnOrientation,omitempty"`
+	SingleTitle    string                     `json:"singleTitle,omitempty"`
+	SingleURL      string                     `json:"singleURL,omitempty"`
+	Btns           []DingDingReqActionCardBtn `json:"btns,omitempty"`
+}
+
+// DingDingReqLink dingding req link schema structure
+type DingDingReqLink struct {
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+	MessageURL string `json:"messageUrl"`
+	PicURL     string `json:"picUrl,omitempty"`
+}
+
+// DingDingReqFeedCardLink dingding req feedCard link structure
+type DingDingReqFeedCardLink struct {
+	Title      string `json:"title"`
+	MessageURL string `json:"messageURL"`
+	PicURL     string `json:"picURL,omitempty"`
+}
+
+// DingDingReqFeedCard dingding req feedCard schema structure
+type DingDingReqFeedCard struct {
+	Links []DingDingReqFeedCardLink `json:"links"`
+}
+
+// DingDingReqCardBodyInfo dingding req body structure of card schemas, which can not at anyone
+type DingDingReqCardBodyInfo struct {
+	MsgType    string                 `json:"msgtype"`
+	ActionCard *DingDingReqActionCard `json:"actionCard,omitempty"`
+	Link       *DingDingReqLink       `json:"link,omitempty"`
+	FeedCard   *DingDingReqFeedCard   `json:"feedCard,omitempty"`
+}
+
+// ButtonConfig button of actionCard, title and url are templates,
+// e.g. {"title": "Kibana", "url": "https://kibana/app/discover#/?_a=(query:'{{.NodeName}}')"}
+type ButtonConfig struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+
+	title *template.Template
+	url   *template.Template
+}
+
+// ActionCardConfig actionCard of alarm msg, title and text come from title and markdown templates,
+// a single button is shown as the whole card link
+type ActionCardConfig struct {
+	BtnOrientation string          `json:"btnOrientation"` // 0 vertical, 1 horizontal
+	Buttons        []*ButtonConfig `json:"buttons"`
+}
+
+// LinkConfig link of alarm msg, title and text come from title and text templates, urls are templates
+type LinkConfig struct {
+	MessageURL string `json:"messageUrl"`
+	PicURL     string `json:"picUrl"`
+
+	messageURL *template.Template
+	picURL     *template.Template
+}
+
+// FeedCardLinkConfig one link of feedCard, all are templates
+type FeedCardLinkConfig struct {
+	Title      string `json:"title"`
+	MessageURL string `json:"messageUrl"`
+	PicURL     string `json:"picUrl"`
+
+	title      *template.Template
+	messageURL *template.Template
+	picURL     *template.Template
+}
+
+// FeedCardConfig feedCard of alarm msg
+type FeedCardConfig struct {
+	Links []*FeedCardLinkConfig `json:"links"`
+}
+
+// compileOptionalTemplate compile the template if it is not empty
+func compileOptionalTemplate(name, text string, sample TemplateData) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	return compileCheckedTemplate(name, text, sample)
+}
+
+func (config *ActionCardConfig) compile(sample TemplateData) error {
+	if config == nil {
+		return nil
+	}
+
+	var err error
+	for i, button := range config.Buttons {
+		if button.Title == "" || button.URL == "" {
+			return fmt.Errorf("actionCard button %d needs title and url", i)
+		}
+
+		button.title, err = compileCheckedTemplate("actionCard button title", button.Title, sample)
+		if err != nil {
+			return err
+		}
+		button.url, err = compileCheckedTemplate("actionCard button url", button.URL, sample)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (config *LinkConfig) compile(sample TemplateData) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.MessageURL == "" {
+		return fmt.Errorf("link needs messageUrl")
+	}
+
+	var err error
+	config.messageURL, err = compileCheckedTemplate("link messageUrl", config.MessageURL, sample)
+	if err != nil {
+		return err
+	}
+	config.picURL, err = compileOptionalTemplate("link picUrl", config.PicURL, sample)
+
+	return err
+}
+
+func (config *FeedCardConfig) compile(sample TemplateData) error {
+	if config == nil {
+		return nil
+	}
+
+	var err error
+	for i, link := range config.Links {
+		if link.Title == "" || link.MessageURL == "" {
+			return fmt.Errorf("feedCard link %d needs title and messageUrl", i)
+		}
+
+		link.title, err = compileCheckedTemplate("feedCard link title", link.Title, sample)
+		if err != nil {
+			return err
+		}
+		link.messageURL, err = compileCheckedTemplate("feedCard link messageUrl", link.MessageURL, sample)
+		if err != nil {
+			return err
+		}
+		link.picURL, err = compileOptionalTemplate("feedCard link picUrl", link.PicURL, sample)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateActionCardBody generate actionCard schema alarm msg
+func generateActionCardBody(config *ActionCardConfig, title, text string, data TemplateData) ([]byte, error) {
+	if config == nil || len(config.Buttons) == 0 {
+		return nil, fmt.Errorf("actionCard is not configured")
+	}
+
+	actionCard := &DingDingReqActionCard{
+		Title:          title,
+		Text:           text,
+		BtnOrientation: config.BtnOrientation,
+	}
+	if len(config.Buttons) == 1 {
+		actionCard.SingleTitle = renderTemplate(config.Buttons[0].title, data)
+		actionCard.SingleURL = renderTemplate(config.Buttons[0].url, data)
+	} else {
+		for _, button := range config.Buttons {
+			actionCard.Btns = append(actionCard.Btns, DingDingReqActionCardBtn{
+				Title:     renderTemplate(button.title, data),
+				ActionURL: renderTemplate(button.url, data),
+			})
+		}
+	}
+
+	return json.Marshal(DingDingReqCardBodyInfo{
+		MsgType:    SchemaActionCard,
+		ActionCard: actionCard,
+	})
+}
+
+// generateLinkBody generate link schema alarm msg
+func generateLinkBody(config *LinkConfig, title, text string, data TemplateData) ([]byte, error) {
+	if config == nil {
+		return nil, fmt.Errorf("link is not configured")
+	}
+
+	return json.Marshal(DingDingReqCardBodyInfo{
+		MsgType: SchemaLink,
+		Link: &DingDingReqLink{
+			Title:      title,
+			Text:       text,
+			MessageURL: renderTemplate(config.messageURL, data),
+			PicURL:     renderTemplate(config.picURL, data),
+		},
+	})
+}
+
+// generateFeedCardBody generate feedCard schema alarm msg
+func generateFeedCardBody(config *FeedCardConfig, data TemplateData) ([]byte, error) {
+	if config == nil || len(config.Links) == 0 {
+		return nil, fmt.Errorf("feedCard is not configured")
+	}
+
+	feedCard := &DingDingReqFeedCard{}
+	for _, link := range config.Links {
+		feedCard.Links = append(feedCard.Links, DingDingReqFeedCardLink{
+			Title:      fitTitle(renderTemplate(link.title, data)),
+			MessageURL: renderTemplate(link.messageURL, data),
+			PicURL:     renderTemplate(link.picURL, data),
+		})
+	}
+
+	return json.Marshal(DingDingReqCardBodyInfo{
+		MsgType:  SchemaFeedCard,
+		FeedCard: feedCard,
+	})
+}
@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"log"
 	"strings"
 	"sync"
 	"time"
@@ -25,13 +22,32 @@ type LogDataInfo struct {
 	Msg          string   `json:"message"`
 	IsAtAll      bool     `json:"isAtAll"`
 	AtMobiles    []string `json:"atMobiles"`
+	AtUserIds    []string `json:"atUserIds"`
+	Severity     string   `json:"severity"` // critical, error, warning, info or empty if not classified
+
+	Fields map[string]string      `json:"fields"` // all extracted fields
+	Raw    map[string]interface{} `json:"-"`      // the whole log json
+}
+
+// newLogDataInfo make LogDataInfo of extracted fields
+func newLogDataInfo(fields map[string]string, raw map[string]interface{}) LogDataInfo {
+	return LogDataInfo{
+		MachineName:  fields[FieldMachineName],
+		GamePlatform: fields[FieldGamePlatform],
+		NodeName:     fields[FieldNodeName],
+		FileName:     fields[FieldFileName],
+		Msg:          fields[FieldMessage],
+		Fields:       fields,
+		Raw:          raw,
+	}
 }
 
 // AlarmDataInfo alarm data structure
 type AlarmDataInfo struct {
 	Msg       string   `json:"message"`
 	IsAtAll   bool     `json:"isAtAll"`
-	AtMobiles []string `json:"atMobeles"`
+	AtMobiles []string `json:"atMobiles"`
+	AtUserIds []string `json:"atUserIds"`
 }
 
 // DingDingReqMarkdown dingding req markdown schema structure
@@ -46,12 +62,53 @@ type DingDingReqText struct {
 }
 
 // DingDingReqAtInfo dingding req at structure
-// for dingding's bug, ony IsAtAll can be used when schema is markdown
+// dingding only notifies mobiles and user ids which @ appear in text and markdown, see mentionText
 type DingDingReqAtInfo struct {
 	AtMobiles []string `json:"atMobiles"`
+	AtUserIds []string `json:"atUserIds"`
 	IsAtAll   bool     `json:"isAtAll"`
 }
 
+// mentionText @ tokens of the mobiles and user ids, at all needs none
+func mentionText(at DingDingReqAtInfo) string {
+	tokens := make([]string, 0, len(at.AtMobiles)+len(at.AtUserIds))
+	for _, mobile := range at.AtMobiles {
+		tokens = append(tokens, "@"+mobile)
+	}
+	for _, userID := range at.AtUserIds {
+		tokens = append(tokens, "@"+userID)
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// withMention put @ tokens at the end of content
+func withMention(content, sep string, at DingDingReqAtInfo) string {
+	mention := mentionText(at)
+	if mention == "" {
+		return content
+	}
+
+	return content + sep + mention
+}
+
+// atInfo who the log at
+func (logData LogDataInfo) atInfo() DingDingReqAtInfo {
+	return DingDingReqAtInfo{
+		AtMobiles: logData.AtMobiles,
+		AtUserIds: logData.AtUserIds,
+		IsAtAll:   logData.IsAtAll,
+	}
+}
+
+// withoutMention the log at nobody, for summaries
+func (logData LogDataInfo) withoutMention() LogDataInfo {
+	logData.IsAtAll = false
+	logData.AtMobiles = nil
+	logData.AtUserIds = nil
+	return logData
+}
+
 // DingDingReqBodyInfo dingding req body structure
 type DingDingReqBodyInfo struct {
 	MsgType  string              `json:"msgtype"`
@@ -62,314 +119,487 @@ type DingDingReqBodyInfo struct {
 
 // DingDingPublisher dingding publisher structure
 type DingDingPublisher struct {
-	client     *http.Client
-	tokenIndex int
+	opts       *Options
+	limiter    *RobotRateLimiter
+	pool       *PublisherPool
+	deadLetter *DeadLetterProducer
+	silences   *SilenceStore
+	dedup      *Deduplicator
+	digester   *Digester
+	thresholds *Thresholder
+	resolver   *Resolver
+	topic      string
+	tokenIndex map[string]int // next robot of every route
 	schema     string
 	filter     *MsgFilterConfig
 	mutex      sync.RWMutex
 }
 
 // NewDingDingPublisher create dingding publisher
-func NewDingDingPublisher(filter *MsgFilterConfig) (*DingDingPublisher, error) {
-	schema := "text"
+func NewDingDingPublisher(opts *Options, topic string, filter *MsgFilterConfig, limiter *RobotRateLimiter,
+	deadLetter *DeadLetterProducer, silences *SilenceStore) (*DingDingPublisher, error) {
+	schema := SchemaText
 	if filter.Schema != "" {
 		schema = filter.Schema
 	}
 
-	var err error
+	pool, err := NewPublisherPool(opts.PublisherNum, opts.PublisherQueueSize, opts.PublisherOverflow)
+	if err != nil {
+		return nil, err
+	}
+
 	publisher := &DingDingPublisher{
+		opts:       opts,
+		limiter:    limiter,
+		pool:       pool,
+		deadLetter: deadLetter,
+		silences:   silences,
+		topic:      topic,
 		filter:     filter,
 		schema:     schema,
-		tokenIndex: 0,
+		tokenIndex: make(map[string]int),
 	}
 
-	publisher.client = &http.Client{}
+	publisher.dedup = NewDeduplicator(publisher.sendSummary)
+	publisher.digester = NewDigester(topic, opts.SyncInterval, publisher.sendSummary)
+	publisher.thresholds = NewThresholder()
+	publisher.resolver = NewResolver(publisher.sendSummary)
 
 	return publisher, err
 }
 
+// currentFilter snapshot of filter config, config updates replace it rather than modify it
+func (publisher *DingDingPublisher) currentFilter() (*MsgFilterConfig, string) {
+	publisher.mutex.RLock()
+	defer publisher.mutex.RUnlock()
+
+	return publisher.filter, publisher.schema
+}
+
 // generateMarkDownBody 生成markdown格式报警信息
-func generateMarkDownBody(logData LogDataInfo) ([]byte, error) {
-	machineStr := ""
-	if logData.MachineName != "" {
-		machineStr = fmt.Sprintf("机器名:**%s**\n\n", logData.MachineName)
-	}
+func generateMarkDownBody(logData LogDataInfo, title, text string) ([]byte, error) {
+	at := logData.atInfo()
 	reqBody := DingDingReqBodyInfo{
 		MsgType: "markdown",
 		Markdown: DingDingReqMarkdown{
-			Title: fmt.Sprintf("%s\n", logData.Msg),
-			Text: fmt.Sprintf("\n\n## %s渠道%s节点报错收集\n\n%s文件名:**%s**\n```lua\n%s\n```",
-				logData.GamePlatform, logData.NodeName, machineStr, logData.FileName, logData.Msg),
-		},
-		At: DingDingReqAtInfo{
-			AtMobiles: logData.AtMobiles,
-			IsAtAll:   logData.IsAtAll,
+			Title: title,
+			Text:  withMention(text, "\n\n", at),
 		},
+		At: at,
 	}
 
 	return json.Marshal(reqBody)
 }
 
 // generateTextBody generate text schema alarm msg
-func generateTextBody(logData LogDataInfo) ([]byte, error) {
-	machineStr := ""
-	if logData.MachineName != "" {
-		machineStr = logData.MachineName
-	}
+func generateTextBody(logData LogDataInfo, content string) ([]byte, error) {
+	at := logData.atInfo()
 	reqBody := DingDingReqBodyInfo{
 		MsgType: "text",
 		Text: DingDingReqText{
-			// Content: fmt.Sprintf("%s\n%s渠道%s节点报错收集\n%s\n%s\n", logData.Msg, logData.GamePlatform, logData.NodeName, machineStr, logData.FileName),
-			Content: fmt.Sprintf("%s\n主题: %s(%s) 节点报错收集\n机器: %s\n文件: %s", logData.Msg, logData.GamePlatform,
-				logData.NodeName, machineStr, logData.FileName),
-		},
-		At: DingDingReqAtInfo{
-			AtMobiles: logData.AtMobiles,
-			IsAtAll:   logData.IsAtAll,
+			Content: withMention(content, "\n", at),
 		},
+		At: at,
 	}
 
 	return json.Marshal(reqBody)
 }
 
+// generateLogBodies generate alarm msgs of the style, content is truncated or split so that every request body
+// is within max body bytes
+func generateLogBodies(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo, data TemplateData) ([][]byte, error) {
+	templates := filter.templates
+	one := func(body []byte, err error) ([][]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{body}, nil
+	}
+
+	switch style.schema {
+	case SchemaText:
+		return fitBodies(templates.renderText(data), filter.MaxBodyBytes, filter.OversizePolicy, filter.MaxSplitParts,
+			func(parts []string) ([][]byte, error) {
+				var bodies [][]byte
+				for _, content := range parts {
+					body, err := generateTextBody(logData, content)
+					if err != nil {
+						return nil, err
+					}
+					bodies = append(bodies, body)
+				}
+				return bodies, nil
+			})
+	case SchemaActionCard:
+		title := fitTitle(templates.renderTitle(data))
+		return fitBodies(templates.renderMarkdown(data), filter.MaxBodyBytes, OversizeTruncate, 0,
+			func(parts []string) ([][]byte, error) {
+				return one(generateActionCardBody(style.actionCard, title, parts[0], data))
+			})
+	case SchemaLink:
+		title := fitTitle(templates.renderTitle(data))
+		return fitBodies(templates.renderText(data), filter.MaxBodyBytes, OversizeTruncate, 0,
+			func(parts []string) ([][]byte, error) {
+				return one(generateLinkBody(style.link, title, parts[0], data))
+			})
+	case SchemaFeedCard:
+		// link titles are fit on their own, there is no content to cut if the urls are still too long
+		body, err := generateFeedCardBody(style.feedCard, data)
+		if err != nil {
+			return nil, err
+		}
+		if overflow := overflowBytes([][]byte{body}, filter.MaxBodyBytes); overflow > 0 {
+			return nil, &permanentError{fmt.Errorf("feedCard can not fit, %d bytes over", overflow)}
+		}
+		return [][]byte{body}, nil
+	}
+
+	title := fitTitle(templates.renderTitle(data))
+	return fitBodies(templates.renderMarkdown(data), filter.MaxBodyBytes, filter.OversizePolicy, filter.MaxSplitParts,
+		func(parts []string) ([][]byte, error) {
+			// split parts are numbered in their text, titles are left as they are
+			var bodies [][]byte
+			for _, text := range parts {
+				body, err := generateMarkDownBody(logData, title, text)
+				if err != nil {
+					return nil, err
+				}
+				bodies = append(bodies, body)
+			}
+			return bodies, nil
+		})
+}
+
 func generateAlarmTextBody(alarmData AlarmDataInfo) ([]byte, error) {
+	at := DingDingReqAtInfo{
+		AtMobiles: alarmData.AtMobiles,
+		AtUserIds: alarmData.AtUserIds,
+		IsAtAll:   alarmData.IsAtAll,
+	}
 	reqBody := DingDingReqBodyInfo{
 		MsgType: "text",
 		Text: DingDingReqText{
-			Content: alarmData.Msg,
-		},
-		At: DingDingReqAtInfo{
-			AtMobiles: alarmData.AtMobiles,
-			IsAtAll:   alarmData.IsAtAll,
+			Content: withMention(alarmData.Msg, "\n", at),
 		},
+		At: at,
 	}
 
 	return json.Marshal(reqBody)
 }
 
-// generateAccessToken get access token by loop
-func (publisher *DingDingPublisher) generateAccessToken() TokenSecret {
+// errRateLimited all robots are used up
+var errRateLimited = errors.New("all robots are rate limited")
+
+// acquireTokenSecret pick a robot of the route filter which still has quota by loop,
+// wait for the earliest quota when all robots are used up and give up after rate limit wait
+func (publisher *DingDingPublisher) acquireTokenSecret(filter *MsgFilterConfig) (TokenSecret, error) {
+	deadline := time.Now().Add(publisher.opts.PublisherRateLimitWait)
+	for {
+		tokenSecret, wait, err := publisher.takeTokenSecret(filter, time.Now())
+		if err != nil || wait == 0 {
+			return tokenSecret, err
+		}
+
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return tokenSecret, errRateLimited
+		}
+		if wait > remain {
+			wait = remain
+		}
+		time.Sleep(wait)
+	}
+}
+
+// takeTokenSecret take quota of the first robot which has any, start from token index of the route,
+// return the shortest wait when all robots are used up
+func (publisher *DingDingPublisher) takeTokenSecret(filter *MsgFilterConfig, now time.Time) (TokenSecret, time.Duration, error) {
 	var tokenSecret TokenSecret
 
-	publisher.mutex.RLock()
-	defer publisher.mutex.RUnlock()
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
 
-	if len(publisher.filter.TokenSecrets) == 0 {
-		return tokenSecret
+	tokenSecrets := filter.robots()
+	if len(tokenSecrets) == 0 {
+		return tokenSecret, 0, &permanentError{errors.New("not any dingding token")}
 	}
 
-	tokenSecret = publisher.filter.TokenSecrets[publisher.tokenIndex]
-	publisher.tokenIndex = publisher.tokenIndex + 1
-	if publisher.tokenIndex == len(publisher.filter.TokenSecrets) {
-		publisher.tokenIndex = 0
+	var minWait time.Duration
+	for i := 0; i < len(tokenSecrets); i++ {
+		index := (publisher.tokenIndex[filter.routeName] + i) % len(tokenSecrets)
+		ok, wait := publisher.limiter.take(filter.rateKey(tokenSecrets[index]), filter.rateLimit(), now)
+		if ok {
+			publisher.tokenIndex[filter.routeName] = (index + 1) % len(tokenSecrets)
+			return tokenSecrets[index], 0, nil
+		}
+
+		if i == 0 || wait < minWait {
+			minWait = wait
+		}
 	}
 
-	return tokenSecret
+	return tokenSecret, minWait, nil
 }
 
-func hmacSha256(stringToSign, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	_, _ = h.Write([]byte(stringToSign))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+// sendMsg send msg to the sink of the route filter by any robot which has quota,
+// retry with backoff when the failure is retryable
+func (publisher *DingDingPublisher) sendMsg(filter *MsgFilterConfig, reqBodyJSON []byte) error {
+	sink := filter.sink()
+	maxRetries := publisher.opts.PublisherMaxRetries
+	for attempt := 0; ; attempt++ {
+		tokenSecret, err := publisher.acquireTokenSecret(filter)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), publisher.opts.HTTPClientRequestTimeout)
+			err = sink.Send(ctx, filter, reqBodyJSON, tokenSecret)
+			cancel()
+			if err == nil {
+				return nil
+			}
+		}
+
+		if isRateLimited(err) {
+			// let other robots take over until its quota comes back
+			publisher.limiter.drain(filter.rateKey(tokenSecret), time.Now())
+		}
+
+		if err == errRateLimited {
+			log.Printf("sendMsg shed, %v: %s", err, string(reqBodyJSON))
+			return err
+		}
+
+		if !isRetryable(err) {
+			log.Printf("sendMsg fail, not retryable: %v %s", err, string(reqBodyJSON))
+			return err
+		}
+
+		if attempt >= maxRetries {
+			log.Printf("sendMsg fail after %d retries: %v %s", attempt, err, string(reqBodyJSON))
+			return err
+		}
+
+		backoff := backoffDuration(attempt, publisher.opts.PublisherRetryBackoff, publisher.opts.PublisherMaxRetryBackoff)
+		if wait := retryAfter(err); wait > backoff {
+			backoff = wait
+		}
+		log.Printf("sendMsg fail, retry %d/%d after %s: %v", attempt+1, maxRetries, backoff, err)
+		time.Sleep(backoff)
+	}
 }
 
-func (publisher *DingDingPublisher) sendDingDingMsg(reqBodyJSON []byte, tokenSecret TokenSecret) {
-	publisher.mutex.RLock()
-	secretKey := tokenSecret.Secret
-	timestamp := time.Now().UnixNano() / 1e6
-	stringToSign := fmt.Sprintf("%d\n%s", timestamp, secretKey)
-	sign := hmacSha256(stringToSign, secretKey)
+// publish queue msgs to robots of the route filter for publisher workers, they are sent in order by one worker,
+// the nsq message goes to dead letter topic if any fails or the pool drops them
+func (publisher *DingDingPublisher) publish(filter *MsgFilterConfig, m *nsq.Message, reqBodies ...[]byte) {
+	publisher.pool.submit(publishTask{
+		send: func() {
+			for _, reqBodyJSON := range reqBodies {
+				err := publisher.sendMsg(filter, reqBodyJSON)
+				if err != nil {
+					publisher.deadLetter.publish(DeadLetterDeliveryError, publisher.topic, m, err)
+					return
+				}
+			}
+		},
+		drop: func(err error) {
+			publisher.deadLetter.publish(DeadLetterDropped, publisher.topic, m, err)
+		},
+	})
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s?access_token=%s&timestamp=%d&sign=%s", publisher.filter.Protocol,
-		publisher.filter.URL, tokenSecret.Token, timestamp, sign), bytes.NewReader(reqBodyJSON))
-	if err != nil {
-		publisher.mutex.RUnlock()
-		fmt.Printf("sendDingDingMsg fail:%v %s", err, string(reqBodyJSON))
+// todo: 使用etcd读取配置
+func (publisher *DingDingPublisher) filterMessage(m *nsq.Message, logData LogDataInfo) {
+	if silence := publisher.silences.silenced(publisher.topic, logData.Fields, time.Now()); silence != nil {
 		return
 	}
-	publisher.mutex.RUnlock()
 
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := publisher.client.Do(req)
-	if err != nil {
-		fmt.Printf("sendDingDingMsg do fail:%v", err)
-		return
+	filter, schema := publisher.currentFilter()
+
+	logData.Severity = filter.classify(logData, filter.matchRule(logData))
+	for _, routeFilter := range filter.routeFilters(publisher.topic, logData) {
+		publisher.routeMessage(routeFilter, schema, m, logData)
 	}
+}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("sendDingDingMsg fail:%s\n", string(body))
+// routeMessage send the alarm by the route filter
+func (publisher *DingDingPublisher) routeMessage(filter *MsgFilterConfig, schema string, m *nsq.Message,
+	logData LogDataInfo) {
+	if filter.isIgnored(logData) {
 		return
 	}
-}
 
-// todo: 使用etcd读取配置
-func (publisher *DingDingPublisher) filterMessage(machineName, gamePlatform, nodeName, fileName, msg string) {
-	isIgnore := true
+	if len(filter.robots()) == 0 {
+		return
+	}
 
-	publisher.mutex.RLock()
-	defer publisher.mutex.RUnlock()
+	now := time.Now()
+	rule := filter.matchRule(logData)
+	windows := filter.activeWindows(logData, rule, now)
+	at := filter.mention(logData, rule)
+	for _, window := range windows {
+		if window.NotAt {
+			at = DingDingReqAtInfo{}
+		}
+	}
+	logData.IsAtAll, logData.AtMobiles, logData.AtUserIds = at.IsAtAll, at.AtMobiles, at.AtUserIds
 
-	// only special keys need alarm
-	if len(publisher.filter.FilterKeys) > 0 {
-		for _, value := range publisher.filter.FilterKeys {
-			if strings.Contains(msg, value) {
-				isIgnore = false
-				break
-			}
+	var rate string
+	if rule != nil {
+		reached, count := true, 1
+		if rule.Threshold != nil {
+			reached, count = publisher.thresholds.count(rule, filter.routeName, logData, now)
+		}
+		publisher.resolver.observe(rule, filter.routeName, m, logData, reached, count, now)
+		if !reached {
+			return
+		}
+		if rule.Threshold != nil {
+			rate = formatRate(count, time.Duration(rule.Threshold.Window))
 		}
-	} else {
-		// alarm all messages when filter keys are not existed
-		isIgnore = false
 	}
 
-	// some keys need ignore
-	for _, value := range publisher.filter.IgnoreKeys {
-		if strings.Contains(msg, value) {
-			isIgnore = true
-			break
+	for _, window := range windows {
+		if window.Digest {
+			publisher.digester.deferUntilEnd(filter.Digest, filter.routeName, window, m, logData)
+			return
 		}
 	}
 
-	if isIgnore {
+	if filter.Digest.hasTopic(publisher.topic) {
+		publisher.digester.add(filter.Digest, filter.routeName, m, logData)
 		return
 	}
 
-	tokenSecret := publisher.generateAccessToken()
-	if tokenSecret.Token == "" {
+	if !publisher.dedup.admit(filter.Dedup, filter.routeName, m, logData, now) {
 		return
 	}
 
-	isAtAll := true
-	// not at all people for some key
-	for _, value := range publisher.filter.NotAtKeys {
-		if strings.Contains(msg, value) {
-			isAtAll = false
-			break
-		}
+	templateData := TemplateData{
+		LogDataInfo: logData,
+		Topic:       publisher.topic,
+		RawJSON:     string(m.Body),
+		Time:        now,
+		Marker:      filter.marker(logData.Severity),
+		Rate:        rate,
 	}
 
-	// don't at all when at mobiles exist
-	if len(publisher.filter.AtMobiles) > 0 {
-		isAtAll = false
+	if filter.routeName != "" && filter.Schema != "" {
+		schema = filter.Schema
+	}
+	style := newMsgStyle(filter, schema, rule)
+	reqBodies, err := filter.sink().Render(style, filter, logData, templateData)
+	if err != nil {
+		log.Printf("routeMessage render fail: %v", err)
+		publisher.deadLetter.publish(DeadLetterRenderError, publisher.topic, m, err)
+		return
 	}
 
-	logData := LogDataInfo{
-		MachineName:  machineName,
-		GamePlatform: gamePlatform,
-		NodeName:     nodeName,
-		FileName:     fileName,
-		Msg:          msg,
-		IsAtAll:      isAtAll,
-		AtMobiles:    publisher.filter.AtMobiles,
-	}
-
-	var reqBodyJSON []byte
-	var err error
-	if publisher.schema == "text" {
-		reqBodyJSON, err = generateTextBody(logData)
-	} else {
-		reqBodyJSON, err = generateMarkDownBody(logData)
+	publisher.publish(filter, m, reqBodies...)
+}
+
+// sourceData template data of the message which a text msg is about
+func (publisher *DingDingPublisher) sourceData(m *nsq.Message) TemplateData {
+	return TemplateData{
+		Topic:   publisher.topic,
+		RawJSON: string(m.Body),
+		Time:    time.Now(),
 	}
+}
+
+// sendSummary send summary of suppressed or digested alarms of the route in text without at anyone
+func (publisher *DingDingPublisher) sendSummary(route string, m *nsq.Message, logData LogDataInfo, content string) {
+	filter, _ := publisher.currentFilter()
+	filter = filter.routeFilter(route)
+
+	reqBodies, err := filter.renderText(logData.withoutMention(), content, publisher.sourceData(m))
 	if err != nil {
-		fmt.Printf("filterMessage file:%v", err)
+		log.Printf("sendSummary render fail: %v", err)
+		publisher.deadLetter.publish(DeadLetterRenderError, publisher.topic, m, err)
 		return
 	}
 
-	go publisher.sendDingDingMsg(reqBodyJSON, tokenSecret)
+	publisher.publish(filter, m, reqBodies...)
 }
 
-func (publisher *DingDingPublisher) alarmMessage(msg string) {
-	isIgnore := true
-
-	publisher.mutex.RLock()
-	defer publisher.mutex.RUnlock()
+func (publisher *DingDingPublisher) alarmMessage(m *nsq.Message, msg string) {
+	filter, _ := publisher.currentFilter()
 
-	// only special keys need alarm
-	for _, value := range publisher.filter.FilterKeys {
-		if strings.Contains(msg, value) {
-			isIgnore = false
-			break
-		}
+	logData := LogDataInfo{
+		Msg:    msg,
+		Fields: map[string]string{FieldMessage: msg},
+	}
+	if silence := publisher.silences.silenced(publisher.topic, logData.Fields, time.Now()); silence != nil {
+		return
 	}
 
-	// some keys need ignore
-	for _, value := range publisher.filter.IgnoreKeys {
-		if strings.Contains(msg, value) {
-			isIgnore = true
-			break
-		}
+	logData.Severity = filter.classify(logData, filter.matchRule(logData))
+	for _, routeFilter := range filter.routeFilters(publisher.topic, logData) {
+		publisher.routeAlarmMessage(routeFilter, m, logData)
 	}
+}
 
-	if isIgnore && len(publisher.filter.FilterKeys) > 0 {
+// routeAlarmMessage send the alarm of a bad message by the route filter
+func (publisher *DingDingPublisher) routeAlarmMessage(filter *MsgFilterConfig, m *nsq.Message, logData LogDataInfo) {
+	if filter.isIgnored(logData) {
 		return
 	}
 
-	tokenSecret := publisher.generateAccessToken()
-	if tokenSecret.Token == "" {
+	if len(filter.robots()) == 0 {
 		return
 	}
 
-	isAtAll := true
-	// not at all people for some key
-	for _, value := range publisher.filter.NotAtKeys {
-		if strings.Contains(msg, value) {
-			isAtAll = false
-			break
+	rule := filter.matchRule(logData)
+	at := filter.mention(logData, rule)
+	for _, window := range filter.activeWindows(logData, rule, time.Now()) {
+		if window.NotAt {
+			at = DingDingReqAtInfo{}
 		}
 	}
+	logData.IsAtAll, logData.AtMobiles, logData.AtUserIds = at.IsAtAll, at.AtMobiles, at.AtUserIds
 
-	if len(publisher.filter.AtMobiles) > 0 {
-		isAtAll = false
-	}
-
-	alarmData := AlarmDataInfo{
-		Msg:       msg,
-		IsAtAll:   isAtAll,
-		AtMobiles: publisher.filter.AtMobiles,
+	msg := logData.Msg
+	if marker := filter.marker(logData.Severity); marker != "" {
+		msg = marker + " " + msg
 	}
 
-	reqBodyJson, err := generateAlarmTextBody(alarmData)
+	reqBodies, err := filter.renderText(logData, msg, publisher.sourceData(m))
 	if err != nil {
-		fmt.Printf("generateAlarmTextBody fail: %v %v", err, alarmData)
+		// the bad message itself goes to dead letter topic by handleMessage
+		log.Printf("routeAlarmMessage render fail: %v", err)
 		return
 	}
 
-	go publisher.sendDingDingMsg(reqBodyJson, tokenSecret)
+	publisher.publish(filter, m, reqBodies...)
 }
 
+// handleMessage parse the message and queue its alarms, errPublisherBusy if the queue of drop-newest is full,
+// the message is requeued before any route counts it
 func (publisher *DingDingPublisher) handleMessage(m *nsq.Message) error {
+	if publisher.pool.shedding() {
+		return errPublisherBusy
+	}
+
 	data := make(map[string]interface{})
 	err := json.Unmarshal(m.Body, &data)
 	if err != nil {
 		// alarm text message if unmarshal fail
 		message := string(m.Body)
-		publisher.alarmMessage(message)
-		return nil
+		publisher.alarmMessage(m, message)
+		return &deadLetterError{DeadLetterParseError, err}
 	}
 
-	if data["message"] == nil || data["log"] == nil {
-		message := ""
-		if data["message"] != nil {
-			message = data["message"].(string)
-		} else {
+	filter, _ := publisher.currentFilter()
+	fields, err := filter.fieldMapping.extract(data)
+	if err != nil {
+		message := fields[FieldMessage]
+		if message == "" {
 			message = string(m.Body)
 		}
-		publisher.alarmMessage(message)
-		return err
+		publisher.alarmMessage(m, message)
+		return &deadLetterError{DeadLetterInvalid, err}
 	}
 
-	machineName := ""
-	if data["machineName"] != nil {
-		machineName = data["machineName"].(string)
-	}
-	logData := data["log"].(map[string]interface{})
-	fileData := logData["file"].(map[string]interface{})
-	publisher.filterMessage(machineName, data["gamePlatform"].(string), data["nodeName"].(string),
-		fileData["path"].(string), data["message"].(string))
+	publisher.filterMessage(m, newLogDataInfo(fields, data))
 
-	return err
+	return nil
 }
 
 func (publisher *DingDingPublisher) updateConfig(filter *MsgFilterConfig) {
@@ -378,9 +608,17 @@ func (publisher *DingDingPublisher) updateConfig(filter *MsgFilterConfig) {
 
 	publisher.filter = filter
 	// maybe there are fewer tokens
-	publisher.tokenIndex = 0
+	publisher.tokenIndex = make(map[string]int)
 
 	if filter.Schema != "" {
 		publisher.schema = filter.Schema
 	}
 }
+
+// Close send pending summaries and wait for queued msgs to be sent
+func (publisher *DingDingPublisher) Close() {
+	publisher.resolver.Close()
+	publisher.dedup.Close()
+	publisher.digester.Close()
+	publisher.pool.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DingDingSink sink of dingding robots
+type DingDingSink struct{}
+
+// DefaultURL implement of Sink
+func (sink DingDingSink) DefaultURL() string {
+	return "oapi.dingtalk.com/robot/send"
+}
+
+// Render implement of Sink
+func (sink DingDingSink) Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo,
+	data TemplateData) ([][]byte, error) {
+	return generateLogBodies(style, filter, logData, data)
+}
+
+// RenderText implement of Sink, parts of split content share the same mentions
+func (sink DingDingSink) RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error) {
+	alarmData := AlarmDataInfo{
+		IsAtAll:   logData.IsAtAll,
+		AtMobiles: logData.AtMobiles,
+		AtUserIds: logData.AtUserIds,
+	}
+
+	return fitBodies(content, filter.MaxBodyBytes, filter.OversizePolicy, filter.MaxSplitParts, func(parts []string) ([][]byte, error) {
+		var reqBodies [][]byte
+		for _, part := range parts {
+			alarmData.Msg = part
+			reqBodyJSON, err := generateAlarmTextBody(alarmData)
+			if err != nil {
+				return nil, err
+			}
+			reqBodies = append(reqBodies, reqBodyJSON)
+		}
+		return reqBodies, nil
+	})
+}
+
+func hmacSha256(stringToSign, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Send implement of Sink, sign the request by the secret of the robot, errcode of the response tells failures
+func (sink DingDingSink) Send(ctx context.Context, filter *MsgFilterConfig, reqBodyJSON []byte,
+	tokenSecret TokenSecret) error {
+	secretKey := tokenSecret.Secret
+	timestamp := time.Now().UnixNano() / 1e6
+	stringToSign := fmt.Sprintf("%d\n%s", timestamp, secretKey)
+	sign := hmacSha256(stringToSign, secretKey)
+
+	reqURL := fmt.Sprintf("%s://%s?access_token=%s&timestamp=%d&sign=%s", filter.Protocol, filter.URL,
+		tokenSecret.Token, timestamp, url.QueryEscape(sign))
+	respBody, err := postJSON(ctx, SinkDingDing, http.MethodPost, reqURL, nil, reqBodyJSON)
+	if err != nil {
+		return err
+	}
+
+	return checkErrCode(SinkDingDing, respBody)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeishuReqText feishu req text content structure
+type FeishuReqText struct {
+	Text string `json:"text"`
+}
+
+// FeishuReqCardText feishu card text element, tag is plain_text or lark_md
+type FeishuReqCardText struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+// FeishuReqCardHeader feishu card header, template is its color
+type FeishuReqCardHeader struct {
+	Title    FeishuReqCardText `json:"title"`
+	Template string            `json:"template"`
+}
+
+// FeishuReqCardButton feishu card button
+type FeishuReqCardButton struct {
+	Tag  string            `json:"tag"`
+	Text FeishuReqCardText `json:"text"`
+	URL  string            `json:"url"`
+	Type string            `json:"type"`
+}
+
+// FeishuReqCardElement feishu card element, div of text or action of buttons
+type FeishuReqCardElement struct {
+	Tag     string                `json:"tag"`
+	Text    *FeishuReqCardText    `json:"text,omitempty"`
+	Actions []FeishuReqCardButton `json:"actions,omitempty"`
+}
+
+// FeishuReqCardConfig feishu card config
+type FeishuReqCardConfig struct {
+	WideScreenMode bool `json:"wide_screen_mode"`
+}
+
+// FeishuReqCard feishu interactive card
+type FeishuReqCard struct {
+	Config   FeishuReqCardConfig    `json:"config"`
+	Header   FeishuReqCardHeader    `json:"header"`
+	Elements []FeishuReqCardElement `json:"elements"`
+}
+
+// FeishuReqBodyInfo feishu req body structure, timestamp and sign are set when it is sent
+type FeishuReqBodyInfo struct {
+	Timestamp string         `json:"timestamp,omitempty"`
+	Sign      string         `json:"sign,omitempty"`
+	MsgType   string         `json:"msg_type"`
+	Content   *FeishuReqText `json:"content,omitempty"`
+	Card      *FeishuReqCard `json:"card,omitempty"`
+}
+
+// FeishuRespInfo feishu bot response structure, old bots answer StatusCode and StatusMessage
+type FeishuRespInfo struct {
+	Code          int    `json:"code"`
+	Msg           string `json:"msg"`
+	StatusCode    int    `json:"StatusCode"`
+	StatusMessage string `json:"StatusMessage"`
+}
+
+// feishuHeaderColors header color of card by severity
+var feishuHeaderColors = map[string]string{
+	SeverityCritical: "red",
+	SeverityError:    "orange",
+	SeverityWarning:  "yellow",
+	SeverityInfo:     "blue",
+}
+
+// FeishuSink sink of feishu or lark custom bots, token is the id at the end of webhook url,
+// feishu bots can only at user ids(open_id), not mobiles
+type FeishuSink struct{}
+
+// DefaultURL implement of Sink, it is open.larksuite.com/open-apis/bot/v2/hook for lark
+func (sink FeishuSink) DefaultURL() string {
+	return "open.feishu.cn/open-apis/bot/v2/hook"
+}
+
+// feishuTextMention at tokens of text msg
+func feishuTextMention(logData LogDataInfo) string {
+	var tokens []string
+	if logData.IsAtAll {
+		tokens = append(tokens, `<at user_id="all">所有人</at>`)
+	}
+	for _, userID := range logData.AtUserIds {
+		tokens = append(tokens, fmt.Sprintf(`<at user_id="%s"></at>`, userID))
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// feishuCardMention at tokens of lark_md in card
+func feishuCardMention(logData LogDataInfo) string {
+	var tokens []string
+	if logData.IsAtAll {
+		tokens = append(tokens, "<at id=all></at>")
+	}
+	for _, userID := range logData.AtUserIds {
+		tokens = append(tokens, fmt.Sprintf("<at id=%s></at>", userID))
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+func generateFeishuTextBody(logData LogDataInfo, content string) ([]byte, error) {
+	if mention := feishuTextMention(logData); mention != "" {
+		content += "\n" + mention
+	}
+
+	return json.Marshal(FeishuReqBodyInfo{
+		MsgType: "text",
+		Content: &FeishuReqText{Text: content},
+	})
+}
+
+func generateFeishuCardBody(logData LogDataInfo, title, text string, buttons []FeishuReqCardButton) ([]byte, error) {
+	if mention := feishuCardMention(logData); mention != "" {
+		text += "\n" + mention
+	}
+
+	color, ok := feishuHeaderColors[logData.Severity]
+	if !ok {
+		color = "red"
+	}
+
+	card := &FeishuReqCard{
+		Config: FeishuReqCardConfig{WideScreenMode: true},
+		Header: FeishuReqCardHeader{
+			Title:    FeishuReqCardText{Tag: "plain_text", Content: title},
+			Template: color,
+		},
+		Elements: []FeishuReqCardElement{
+			{Tag: "div", Text: &FeishuReqCardText{Tag: "lark_md", Content: text}},
+		},
+	}
+	if len(buttons) > 0 {
+		card.Elements = append(card.Elements, FeishuReqCardElement{Tag: "action", Actions: buttons})
+	}
+
+	return json.Marshal(FeishuReqBodyInfo{
+		MsgType: "interactive",
+		Card:    card,
+	})
+}
+
+// feishuButtons card buttons of the links of the style
+func feishuButtons(style msgStyle, data TemplateData) []FeishuReqCardButton {
+	var buttons []FeishuReqCardButton
+	for _, link := range styleLinks(style, data) {
+		buttons = append(buttons, FeishuReqCardButton{
+			Tag:  "button",
+			Text: FeishuReqCardText{Tag: "plain_text", Content: link.title},
+			URL:  link.url,
+			Type: "default",
+		})
+	}
+
+	return buttons
+}
+
+// Render implement of Sink, text schema goes as text msg and others as interactive card,
+// buttons of dingding cards become card buttons
+func (sink FeishuSink) Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo,
+	data TemplateData) ([][]byte, error) {
+	templates := filter.templates
+	if style.schema == SchemaText {
+		return sink.RenderText(filter, logData, templates.renderText(data))
+	}
+
+	title := fitTitle(templates.renderTitle(data))
+	buttons := feishuButtons(style, data)
+	return fitBodies(templates.renderMarkdown(data), filter.MaxBodyBytes, filter.OversizePolicy, filter.MaxSplitParts,
+		func(parts []string) ([][]byte, error) {
+			var bodies [][]byte
+			for _, text := range parts {
+				body, err := generateFeishuCardBody(logData, title, text, buttons)
+				if err != nil {
+					return nil, err
+				}
+				bodies = append(bodies, body)
+			}
+			return bodies, nil
+		})
+}
+
+// RenderText implement of Sink, text msgs with <at> tags
+func (sink FeishuSink) RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error) {
+	return fitBodies(content, filter.MaxBodyBytes, filter.OversizePolicy, filter.MaxSplitParts, func(parts []string) ([][]byte, error) {
+		var bodies [][]byte
+		for _, part := range parts {
+			body, err := generateFeishuTextBody(logData, part)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, body)
+		}
+		return bodies, nil
+	})
+}
+
+// feishuSign sign of feishu bot, secret is in the key rather than the content
+func feishuSign(timestamp int64, secret string) string {
+	h := hmac.New(sha256.New, []byte(fmt.Sprintf("%d\n%s", timestamp, secret)))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Send implement of Sink, the sign goes in the body, old bots answer failures in StatusCode rather than code
+func (sink FeishuSink) Send(ctx context.Context, filter *MsgFilterConfig, reqBodyJSON []byte,
+	tokenSecret TokenSecret) error {
+	if tokenSecret.Secret != "" {
+		var reqBody FeishuReqBodyInfo
+		err := json.Unmarshal(reqBodyJSON, &reqBody)
+		if err != nil {
+			return &permanentError{err}
+		}
+
+		timestamp := time.Now().Unix()
+		reqBody.Timestamp = strconv.FormatInt(timestamp, 10)
+		reqBody.Sign = feishuSign(timestamp, tokenSecret.Secret)
+		reqBodyJSON, err = json.Marshal(reqBody)
+		if err != nil {
+			return &permanentError{err}
+		}
+	}
+
+	respBody, err := postJSON(ctx, SinkFeishu, http.MethodPost, fmt.Sprintf("%s://%s/%s", filter.Protocol,
+		strings.TrimRight(filter.URL, "/"), tokenSecret.Token), nil, reqBodyJSON)
+	if err != nil {
+		return err
+	}
+
+	var respInfo FeishuRespInfo
+	err = json.Unmarshal(respBody, &respInfo)
+	if err != nil {
+		return &RobotError{Sink: SinkFeishu, StatusCode: http.StatusOK, Msg: string(respBody)}
+	}
+
+	if respInfo.Code != 0 || respInfo.StatusCode != 0 {
+		code, msg := respInfo.Code, respInfo.Msg
+		if code == 0 {
+			code, msg = respInfo.StatusCode, respInfo.StatusMessage
+		}
+		return &RobotError{Sink: SinkFeishu, StatusCode: http.StatusOK, Code: code, Msg: msg}
+	}
+
+	return nil
+}
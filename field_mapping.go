@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// names of the fields which make up LogDataInfo
+const (
+	FieldMessage      = "message"
+	FieldMachineName  = "machineName"
+	FieldGamePlatform = "gamePlatform"
+	FieldNodeName     = "nodeName"
+	FieldFileName     = "fileName"
+)
+
+// FieldMappingConfig map a value in log json to an extracted field
+type FieldMappingConfig struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"` // dotted path log.file.path, or JSONPath $.log.file.path, $.tags[0], $['log.level']
+	Default  string `json:"default"`
+	Required bool   `json:"required"`
+}
+
+// defaultFieldMappings filebeat style log json
+var defaultFieldMappings = []FieldMappingConfig{
+	{Name: FieldMessage, Path: "message", Required: true},
+	{Name: FieldMachineName, Path: "machineName"},
+	{Name: FieldGamePlatform, Path: "gamePlatform"},
+	{Name: FieldNodeName, Path: "nodeName"},
+	{Name: FieldFileName, Path: "log.file.path", Required: true},
+}
+
+// pathSegment key of object or index of array
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type fieldExtractor struct {
+	FieldMappingConfig
+	segments []pathSegment
+}
+
+// FieldMapping compiled field mappings
+type FieldMapping struct {
+	extractors []fieldExtractor
+}
+
+// compileFieldMapping compile the configured mappings over the default ones, same name overrides
+func compileFieldMapping(configs []FieldMappingConfig) (*FieldMapping, error) {
+	merged := make([]FieldMappingConfig, 0, len(defaultFieldMappings)+len(configs))
+	indexes := make(map[string]int)
+	all := append(append([]FieldMappingConfig{}, defaultFieldMappings...), configs...)
+	for _, config := range all {
+		if config.Name == "" {
+			return nil, fmt.Errorf("field mapping of path %s has no name", config.Path)
+		}
+
+		if index, ok := indexes[config.Name]; ok {
+			merged[index] = config
+			continue
+		}
+		indexes[config.Name] = len(merged)
+		merged = append(merged, config)
+	}
+
+	mapping := &FieldMapping{}
+	for _, config := range merged {
+		segments, err := parseFieldPath(config.Path)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", config.Name, err)
+		}
+		mapping.extractors = append(mapping.extractors, fieldExtractor{config, segments})
+	}
+
+	return mapping, nil
+}
+
+// parseFieldPath parse dotted path or JSONPath into segments
+func parseFieldPath(path string) ([]pathSegment, error) {
+	rest := strings.TrimSpace(path)
+	if strings.HasPrefix(rest, "$") {
+		rest = strings.TrimPrefix(rest[1:], ".")
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("path %q is empty", path)
+	}
+
+	var segments []pathSegment
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has unclosed [", path)
+			}
+
+			inner := strings.TrimSpace(rest[1:end])
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, pathSegment{key: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("path %q has invalid index [%s]", path, inner)
+				}
+				segments = append(segments, pathSegment{index: index, isIndex: true})
+			}
+
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+			continue
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("path %q has empty key", path)
+		}
+
+		segments = append(segments, pathSegment{key: rest[:end]})
+		rest = rest[end:]
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("path %q ends with .", path)
+			}
+		}
+	}
+
+	return segments, nil
+}
+
+// lookup walk the segments down the json value
+func lookup(data interface{}, segments []pathSegment) (interface{}, bool) {
+	value := data
+	for _, segment := range segments {
+		if segment.isIndex {
+			array, ok := value.([]interface{})
+			if !ok || segment.index >= len(array) {
+				return nil, false
+			}
+			value = array[segment.index]
+			continue
+		}
+
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[segment.key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, value != nil
+}
+
+// coerceString turn any json value into string, objects and arrays are kept as json
+func coerceString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// extract extract all fields from log json, missing fields take their default,
+// missing required fields are reported but the others are still extracted
+func (mapping *FieldMapping) extract(data map[string]interface{}) (map[string]string, error) {
+	fields := make(map[string]string, len(mapping.extractors))
+	var missing []string
+	for _, extractor := range mapping.extractors {
+		value, ok := lookup(data, extractor.segments)
+		if !ok {
+			if extractor.Required {
+				missing = append(missing, extractor.Path)
+			}
+			fields[extractor.Name] = extractor.Default
+			continue
+		}
+
+		fields[extractor.Name] = coerceString(value)
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fields, fmt.Errorf("required fields %s are missing", strings.Join(missing, ", "))
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	key := func(k string) pathSegment { return pathSegment{key: k} }
+	index := func(i int) pathSegment { return pathSegment{index: i, isIndex: true} }
+
+	tests := []struct {
+		path     string
+		segments []pathSegment
+		err      string
+	}{
+		{"message", []pathSegment{key("message")}, ""},
+		{"log.file.path", []pathSegment{key("log"), key("file"), key("path")}, ""},
+		{"$.log.file.path", []pathSegment{key("log"), key("file"), key("path")}, ""},
+		{" $.message ", []pathSegment{key("message")}, ""},
+		{"$.tags[0]", []pathSegment{key("tags"), index(0)}, ""},
+		{"tags[2].name", []pathSegment{key("tags"), index(2), key("name")}, ""},
+		{"$[1][0]", []pathSegment{index(1), index(0)}, ""},
+		{"$['log.level']", []pathSegment{key("log.level")}, ""},
+		{`$["log.level"].name`, []pathSegment{key("log.level"), key("name")}, ""},
+		{"$.fields[ 'game platform' ]", []pathSegment{key("fields"), key("game platform")}, ""},
+		{"", nil, "is empty"},
+		{"$", nil, "is empty"},
+		{"$.", nil, "is empty"},
+		{"tags[0", nil, "unclosed ["},
+		{"tags[-1]", nil, "invalid index"},
+		{"tags[x]", nil, "invalid index"},
+		{"tags['x]", nil, "invalid index"},
+		{"log..path", nil, "empty key"},
+		{"log.", nil, "ends with ."},
+	}
+
+	for _, test := range tests {
+		segments, err := parseFieldPath(test.path)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: error %v, want %s", test.path, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(segments, test.segments) {
+			t.Errorf("%q: segments %+v, want %+v", test.path, segments, test.segments)
+		}
+	}
+}
+
+func TestFieldMappingExtract(t *testing.T) {
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"message": "attempt to index nil",
+		"log": {"file": {"path": "/var/log/game/error.log"}, "level": "error"},
+		"log.level": "flat",
+		"tags": ["ios", 12, true, null, {"k": "v"}],
+		"nodeName": "game1"
+	}`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping, err := compileFieldMapping([]FieldMappingConfig{
+		{Name: "level", Path: "log.level"},
+		{Name: "flatLevel", Path: "$['log.level']"},
+		{Name: "platform", Path: "$.tags[0]"},
+		{Name: "number", Path: "tags[1]"},
+		{Name: "bool", Path: "tags[2]"},
+		{Name: "null", Path: "tags[3]", Default: "none"},
+		{Name: "object", Path: "tags[4]"},
+		{Name: "outOfRange", Path: "tags[9]", Default: "none"},
+		{Name: "notArray", Path: "message[0]"},
+		{Name: "notObject", Path: "message.text"},
+		{Name: FieldGamePlatform, Path: "tags[0]"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := mapping.extract(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		FieldMessage:      "attempt to index nil",
+		FieldMachineName:  "",
+		FieldGamePlatform: "ios",
+		FieldNodeName:     "game1",
+		FieldFileName:     "/var/log/game/error.log",
+		"level":           "error",
+		"flatLevel":       "flat",
+		"platform":        "ios",
+		"number":          "12",
+		"bool":            "true",
+		"null":            "none",
+		"object":          `{"k":"v"}`,
+		"outOfRange":      "none",
+		"notArray":        "",
+		"notObject":       "",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields %v, want %v", fields, want)
+	}
+
+	delete(data, "message")
+	delete(data, "log")
+	fields, err = mapping.extract(data)
+	if err == nil || !strings.Contains(err.Error(), "log.file.path, message") {
+		t.Errorf("missing required fields: error %v", err)
+	}
+	if fields["platform"] != "ios" {
+		t.Errorf("missing required fields: other fields are not extracted, %v", fields)
+	}
+}
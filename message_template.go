@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// TemplateConfig user defined text/template of alarm msg, empty one uses the default
+type TemplateConfig struct {
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"` // html/template of email, values are escaped
+}
+
+// TemplateData data which templates are executed with,
+// fields of LogDataInfo like .Msg and .Fields.xxx are promoted
+type TemplateData struct {
+	LogDataInfo
+	Topic   string
+	RawJSON string
+	Time    time.Time
+	Marker  string // visual marker of the severity, empty if the log is not classified
+	Rate    string // observed rate when threshold of the rule is reached, like 5m0s 内 51 次 (10.2 次/分钟)
+}
+
+const (
+	defaultTitleTemplate    = "{{with .Marker}}{{.}} {{end}}{{.Msg}}\n"
+	defaultTextTemplate     = "{{with .Marker}}{{.}} {{end}}{{.Msg}}\n主题: {{.GamePlatform}}({{.NodeName}}) 节点报错收集\n机器: {{.MachineName}}\n文件: {{.FileName}}{{with .Rate}}\n频率: {{.}}{{end}}"
+	defaultMarkdownTemplate = "\n\n## {{with .Marker}}{{.}} {{end}}{{.GamePlatform}}渠道{{.NodeName}}节点报错收集\n\n" +
+		"{{if .MachineName}}机器名:**{{.MachineName}}**\n\n{{end}}文件名:**{{.FileName}}**\n{{with .Rate}}\n频率:**{{.}}**\n{{end}}```lua\n{{.Msg}}\n```"
+	defaultHTMLTemplate = "<h3>{{with .Marker}}{{.}} {{end}}{{.GamePlatform}}渠道{{.NodeName}}节点报错收集</h3>\n" +
+		"<p>{{if .MachineName}}机器名: <b>{{.MachineName}}</b><br>\n{{end}}文件名: <b>{{.FileName}}</b>{{with .Rate}}<br>\n频率: <b>{{.}}</b>{{end}}</p>\n" +
+		"<pre>{{.Msg}}</pre>\n"
+)
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "#", "\\#",
+	"[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "!", "\\!", "|", "\\|", ">", "\\>",
+)
+
+var templateFuncs = template.FuncMap{
+	// truncate keep at most n runes, {{.Msg | truncate 200}}
+	"truncate":       truncateRunes,
+	"escapeMarkdown": markdownEscaper.Replace,
+	// formatTime format time with go layout, {{formatTime "2006-01-02 15:04:05" .Time}}
+	"formatTime": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"now": time.Now,
+	// default use def when value is empty, {{.MachineName | default "unknown"}}
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// truncateRunes keep at most n runes of s, negative n keeps all
+func truncateRunes(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n]) + "..."
+}
+
+// MsgTemplates compiled templates of alarm msg
+type MsgTemplates struct {
+	title    *template.Template
+	text     *template.Template
+	markdown *template.Template
+	html     *htmltemplate.Template
+}
+
+// msgTemplate text/template or html/template
+type msgTemplate interface {
+	Name() string
+	Execute(w io.Writer, data interface{}) error
+}
+
+var defaultMsgTemplates = &MsgTemplates{
+	title:    template.Must(parseMsgTemplate("title", defaultTitleTemplate)),
+	text:     template.Must(parseMsgTemplate("text", defaultTextTemplate)),
+	markdown: template.Must(parseMsgTemplate("markdown", defaultMarkdownTemplate)),
+	html:     htmltemplate.Must(parseHTMLTemplate("html", defaultHTMLTemplate)),
+}
+
+func parseMsgTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func parseHTMLTemplate(name, text string) (*htmltemplate.Template, error) {
+	return htmltemplate.New(name).Funcs(htmltemplate.FuncMap(templateFuncs)).Option("missingkey=zero").Parse(text)
+}
+
+// sampleTemplateData data to check templates when config is loaded
+func sampleTemplateData() TemplateData {
+	return TemplateData{
+		LogDataInfo: LogDataInfo{
+			MachineName:  "machine",
+			GamePlatform: "platform",
+			NodeName:     "node",
+			FileName:     "file",
+			Msg:          "message \"quoted\" at C:\\path\nnext line", // quotes, backslash and newline reject unescaped json
+			Severity:     SeverityError,
+			Fields:       map[string]string{},
+			Raw:          map[string]interface{}{},
+		},
+		Topic:   "topic",
+		RawJSON: "{}",
+		Time:    time.Now(),
+		Marker:  defaultSeverityConfigs[SeverityError].Marker,
+		Rate:    formatRate(10, time.Minute),
+	}
+}
+
+// compileCheckedTemplate parse the template and try it on sample data
+func compileCheckedTemplate(name, text string, sample TemplateData) (*template.Template, error) {
+	tmpl, err := parseMsgTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tmpl.Execute(&bytes.Buffer{}, sample)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %v", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// renderTemplate execute the template, empty if it fails for this data
+func renderTemplate(tmpl *template.Template, data TemplateData) string {
+	if tmpl == nil {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		log.Printf("execute template %s fail: %v", tmpl.Name(), err)
+		return ""
+	}
+
+	return buf.String()
+}
+
+// compileMsgTemplates parse the templates and try them on sample data, so a bad one is rejected on load
+func compileMsgTemplates(config *TemplateConfig) (*MsgTemplates, error) {
+	templates := *defaultMsgTemplates
+	if config == nil {
+		return &templates, nil
+	}
+
+	sample := sampleTemplateData()
+	for _, item := range []struct {
+		name   string
+		text   string
+		target **template.Template
+	}{
+		{"title", config.Title, &templates.title},
+		{"text", config.Text, &templates.text},
+		{"markdown", config.Markdown, &templates.markdown},
+	} {
+		if item.text == "" {
+			continue
+		}
+
+		tmpl, err := compileCheckedTemplate(item.name, item.text, sample)
+		if err != nil {
+			return nil, err
+		}
+		*item.target = tmpl
+	}
+
+	if config.HTML != "" {
+		tmpl, err := parseHTMLTemplate("html", config.HTML)
+		if err != nil {
+			return nil, err
+		}
+		err = tmpl.Execute(&bytes.Buffer{}, sample)
+		if err != nil {
+			return nil, fmt.Errorf("template html: %v", err)
+		}
+		templates.html = tmpl
+	}
+
+	return &templates, nil
+}
+
+// executeMsgTemplate execute the template, fall back to the default one if it fails for this data
+func executeMsgTemplate(tmpl, defaultTmpl msgTemplate, data TemplateData) string {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err == nil {
+		return buf.String()
+	}
+
+	log.Printf("execute template %s fail, use default: %v", tmpl.Name(), err)
+	buf.Reset()
+	_ = defaultTmpl.Execute(&buf, data)
+	return buf.String()
+}
+
+func (templates *MsgTemplates) renderTitle(data TemplateData) string {
+	return executeMsgTemplate(templates.title, defaultMsgTemplates.title, data)
+}
+
+func (templates *MsgTemplates) renderText(data TemplateData) string {
+	return executeMsgTemplate(templates.text, defaultMsgTemplates.text, data)
+}
+
+func (templates *MsgTemplates) renderMarkdown(data TemplateData) string {
+	return executeMsgTemplate(templates.markdown, defaultMsgTemplates.markdown, data)
+}
+
+func (templates *MsgTemplates) renderHTML(data TemplateData) string {
+	return executeMsgTemplate(templates.html, defaultMsgTemplates.html, data)
+}
@@ -1,17 +1,29 @@
 package main
 
 import (
-	"github.com/nsqio/go-nsq"
+	"fmt"
 	"log"
-	"os"
+	"sync/atomic"
+
+	"github.com/nsqio/go-nsq"
 )
 
+// NSQConsumerStats counters of messages handled by NSQConsumer
+type NSQConsumerStats struct {
+	Finished uint64
+	Failed   uint64
+	Requeued uint64
+	GivenUp  uint64
+}
+
 // NSQConsumer nsq consumer structure
 type NSQConsumer struct {
-	publisher *DingDingPublisher
-	opts      *Options
-	topic     string
-	consumer  *nsq.Consumer
+	publisher  *DingDingPublisher
+	deadLetter *DeadLetterProducer
+	opts       *Options
+	topic      string
+	consumer   *nsq.Consumer
+	stats      NSQConsumerStats
 
 	msgChan chan *nsq.Message
 
@@ -20,9 +32,10 @@ type NSQConsumer struct {
 }
 
 // NewNSQConsumer create NSQConsumer
-func NewNSQConsumer(opts *Options, topic string, cfg *nsq.Config, config *NsqToDingDingConfig) (*NSQConsumer, error) {
+func NewNSQConsumer(opts *Options, topic string, cfg *nsq.Config, config *NsqToDingDingConfig,
+	limiter *RobotRateLimiter, deadLetter *DeadLetterProducer, silences *SilenceStore) (*NSQConsumer, error) {
 	log.Println("NewNSQConsumer topic", topic)
-	publisher, err := NewDingDingPublisher(config.Filter)
+	publisher, err := NewDingDingPublisher(opts, topic, config.Filter, limiter, deadLetter, silences)
 	if err != nil {
 		return nil, err
 	}
@@ -33,13 +46,14 @@ func NewNSQConsumer(opts *Options, topic string, cfg *nsq.Config, config *NsqToD
 	}
 
 	nsqConsumer := &NSQConsumer{
-		publisher: publisher,
-		opts:      opts,
-		topic:     topic,
-		consumer:  consumer,
-		msgChan:   make(chan *nsq.Message, 1),
-		termChan:  make(chan bool),
-		hupChan:   make(chan bool),
+		publisher:  publisher,
+		deadLetter: deadLetter,
+		opts:       opts,
+		topic:      topic,
+		consumer:   consumer,
+		msgChan:    make(chan *nsq.Message, 1),
+		termChan:   make(chan bool),
+		hupChan:    make(chan bool),
 	}
 	consumer.AddHandler(nsqConsumer)
 
@@ -78,14 +92,7 @@ func (nsqConsumer *NSQConsumer) router() {
 		case <-nsqConsumer.hupChan:
 			closeDingDing = true
 		case m := <-nsqConsumer.msgChan:
-			err := nsqConsumer.publisher.handleMessage(m)
-			if err != nil {
-				// 重试
-				m.Requeue(-1)
-				log.Println("NSQConsumer router msg deal fail", err)
-				os.Exit(1)
-			}
-			m.Finish()
+			nsqConsumer.dealMessage(m)
 		}
 
 		if closeDingDing {
@@ -97,9 +104,69 @@ func (nsqConsumer *NSQConsumer) router() {
 			break
 		}
 	}
+
+	nsqConsumer.publisher.Close()
+}
+
+// dealMessage handle the message, only a message which meets a full publisher queue of drop-newest is requeued
+// to nsq with backoff until max attempts, bad ones go to dead letter topic at once,
+// delivery failures are never requeued to nsq: sending is async, they are retried in process by the publisher
+// and then dead-lettered
+func (nsqConsumer *NSQConsumer) dealMessage(m *nsq.Message) {
+	err := nsqConsumer.handleMessage(m)
+	if err == nil {
+		atomic.AddUint64(&nsqConsumer.stats.Finished, 1)
+		m.Finish()
+		return
+	}
+
+	atomic.AddUint64(&nsqConsumer.stats.Failed, 1)
+	if !isRetryable(err) || int(m.Attempts) >= nsqConsumer.opts.MaxAttempts {
+		nsqConsumer.giveUpMessage(m, err)
+		return
+	}
+
+	delay := backoffDuration(int(m.Attempts)-1, nsqConsumer.opts.RequeueDelay, nsqConsumer.opts.MaxRequeueDelay)
+	log.Printf("NSQConsumer %s msg %s deal fail, attempts %d, requeue after %s: %v",
+		nsqConsumer.topic, m.ID, m.Attempts, delay, err)
+	atomic.AddUint64(&nsqConsumer.stats.Requeued, 1)
+	// the publisher is overloaded, so let go-nsq back off RDY as well
+	m.Requeue(delay)
+}
+
+// handleMessage handle the message, a panic is turned into a permanent error
+func (nsqConsumer *NSQConsumer) handleMessage(m *nsq.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &permanentError{fmt.Errorf("panic: %v", r)}
+		}
+	}()
+
+	return nsqConsumer.publisher.handleMessage(m)
+}
+
+// giveUpMessage finish the message which can never be handled, and divert it to dead letter topic
+func (nsqConsumer *NSQConsumer) giveUpMessage(m *nsq.Message, err error) {
+	log.Printf("NSQConsumer %s msg %s deal fail, attempts %d, give up: %v %s",
+		nsqConsumer.topic, m.ID, m.Attempts, err, string(m.Body))
+	atomic.AddUint64(&nsqConsumer.stats.GivenUp, 1)
+	nsqConsumer.deadLetter.publish(deadLetterReason(err), nsqConsumer.topic, m, err)
+	m.Finish()
+}
+
+// LogFailedMessage implement of nsq FailedMessageLogger interface,
+// called when nsq gives up the message for max attempts
+func (nsqConsumer *NSQConsumer) LogFailedMessage(m *nsq.Message) {
+	log.Printf("NSQConsumer %s msg %s exceeds max attempts %d, give up: %s",
+		nsqConsumer.topic, m.ID, m.Attempts, string(m.Body))
+	atomic.AddUint64(&nsqConsumer.stats.GivenUp, 1)
+	nsqConsumer.deadLetter.publish(DeadLetterMaxAttempts, nsqConsumer.topic, m, nil)
 }
 
 // Close close this NSQConsumer
 func (nsqConsumer *NSQConsumer) Close() {
-	log.Println("NSQConsumer Close")
+	log.Printf("NSQConsumer %s Close, finished:%d failed:%d requeued:%d given up:%d dropped:%d", nsqConsumer.topic,
+		atomic.LoadUint64(&nsqConsumer.stats.Finished), atomic.LoadUint64(&nsqConsumer.stats.Failed),
+		atomic.LoadUint64(&nsqConsumer.stats.Requeued), atomic.LoadUint64(&nsqConsumer.stats.GivenUp),
+		nsqConsumer.publisher.pool.Dropped())
 }
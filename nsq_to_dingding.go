@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
 	"syscall"
@@ -45,6 +46,11 @@ func flagSet() *flag.FlagSet {
 
 	fs.String("channel", "nsqToDingDing", "nsq channel")
 	fs.Int("max-in-flight", 200, "max number of messages to allow in flight")
+	fs.Int("max-attempts", 5, "max number of attempts to handle a message before giving it up")
+	fs.Duration("requeue-delay", time.Second, "base delay to requeue a message when the publisher queue of drop-newest is full")
+	fs.Duration("max-requeue-delay", 10*time.Minute, "max delay to requeue a message when the publisher queue of drop-newest is full")
+	fs.String("dead-letter-topic", "", "nsq topic to publish messages which can not be parsed or delivered(disabled if empty)")
+	fs.String("dead-letter-nsqd-tcp-address", "", "nsqd to publish dead letters(default the first nsqd-tcp-address of config)")
 
 	fs.String("output-dir", "/tmp", "directory to write output files to")
 	fs.String("work-dir", "", "directory for in-progress files before moving to output-dir")
@@ -52,11 +58,20 @@ func flagSet() *flag.FlagSet {
 	fs.Duration("lookupd-poll-interval", 6*time.Second, "lookupd poll interval")
 
 	fs.Duration("dial-timeout", 6*time.Second, "dial nsqd timeout")
-	fs.Duration("sync-interval", 30*time.Second, "sync file to dingding duration")
-	fs.Int("publisher-num", 10, "number of concurrent publishers")
+	fs.Duration("sync-interval", 30*time.Second, "duration between summaries of digest topics")
+	fs.Int("publisher-num", 10, "number of concurrent publishers of each topic")
+	fs.Int("publisher-queue-size", 1000, "max number of messages waiting for publishers of each topic")
+	fs.String("publisher-overflow", OverflowBlock, "what to do when publisher queue is full: block, drop-oldest or drop-newest(requeue to nsq)")
+	fs.Int("publisher-max-retries", 3, "max number of retries when dingding send fails with a retryable error")
+	fs.Duration("publisher-retry-backoff", time.Second, "base backoff duration between dingding send retries")
+	fs.Duration("publisher-max-retry-backoff", 30*time.Second, "max backoff duration between dingding send retries")
+	fs.Duration("publisher-rate-limit-wait", time.Minute, "how long a message waits for robot quota before it is shed")
 
 	fs.Duration("http-client-connect-timeout", 2*time.Second, "timeout for HTTP connect")
-	fs.Duration("http-client-request-timeout", 5*time.Second, "timeout for HTTP request")
+	fs.Duration("http-client-request-timeout", 5*time.Second, "timeout for HTTP request, or the session of smtp sink")
+	fs.String("http-address", "", "<addr>:<port> to listen on for HTTP admin API of silences(disabled if empty), "+
+		"it must be localhost like 127.0.0.1:4180 unless --http-token is set")
+	fs.String("http-token", "", "token which requests of HTTP admin API must carry as Authorization: Bearer <token>")
 
 	fs.String("http-protocol", "https", "http protocol(default https)")
 	fs.String("http-url", "oapi.dingtalk.com/robot/send", "http url(default oapi.dingtalk.com/robot/send)")
@@ -81,7 +96,8 @@ func main() {
 		log.Fatalf("parse fail:%v", err)
 	}
 
-	if args := fs.Args(); len(args) > 0 {
+	args := fs.Args()
+	if len(args) > 0 && args[0] != "silence" {
 		log.Fatalf("unknown arguments: %s", args)
 	}
 
@@ -106,10 +122,40 @@ func main() {
 		log.Fatal("--http-client-request-timeout should be positive")
 	}
 
+	if opts.MaxAttempts <= 0 || opts.MaxAttempts > math.MaxUint16 {
+		log.Fatalf("--max-attempts should be between 1 and %d", math.MaxUint16)
+	}
+
+	if opts.DeadLetterTopic != "" && !nsq.IsValidTopicName(opts.DeadLetterTopic) {
+		log.Fatal("--dead-letter-topic is invalid")
+	}
+
+	if opts.SyncInterval <= 0 {
+		log.Fatal("--sync-interval should be positive")
+	}
+
+	if opts.PublisherNum <= 0 {
+		log.Fatal("--publisher-num should be positive")
+	}
+
+	if opts.PublisherQueueSize <= 0 {
+		log.Fatal("--publisher-queue-size should be positive")
+	}
+
+	if err := checkOverflowPolicy(opts.PublisherOverflow); err != nil {
+		log.Fatalf("--publisher-overflow is invalid: %v", err)
+	}
+
+	if opts.PublisherMaxRetries < 0 {
+		log.Fatal("--publisher-max-retries should not be negative")
+	}
+
 	if opts.WorkDir == "" {
 		opts.WorkDir = opts.OutputDir
 	}
 
+	setupSinkClient(opts)
+
 	cfg := nsq.NewConfig()
 	cfgFlag := nsq.ConfigFlag{Config: cfg}
 	for _, opt := range opts.ConsumerOpts {
@@ -117,6 +163,7 @@ func main() {
 	}
 	cfg.UserAgent = fmt.Sprintf("nsq_to_dingding/%s go-nsq/%s", VERSION, nsq.VERSION)
 	cfg.MaxInFlight = opts.MaxInFlight
+	cfg.MaxAttempts = uint16(opts.MaxAttempts)
 	cfg.DialTimeout = fs.Lookup("dial-timeout").Value.(flag.Getter).Get().(time.Duration)
 
 	hupChan := make(chan os.Signal, 1)
@@ -133,6 +180,19 @@ func main() {
 		log.Fatal("error: not any etcd endpoint")
 	}
 
+	if len(args) > 0 {
+		etcdCli, err := newEtcdClient(etcdEndpoints, etcdUsername, etcdPassword)
+		if err != nil {
+			log.Fatal("newEtcdClient fail ", err)
+		}
+		err = runSilenceCommand(NewSilenceStore(etcdCli, etcdPath), args[1:])
+		etcdCli.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// fmt.Printf("full url: %s://%s?accessToken=%s\n", httpProtocol, httpURL, httpAccessToken)
 	discoverer, err := newTopicDiscoverer(opts, cfg, hupChan, termChan,
 		etcdEndpoints, etcdUsername, etcdPassword, etcdPath)
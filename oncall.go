@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// OnCallMember who can be on call
+type OnCallMember struct {
+	Name   string `json:"name"`
+	Mobile string `json:"mobile"`
+	UserID string `json:"userId"` // dingding user id
+}
+
+// RotationConfig members take turns to be on call for every period from start, which is the first handoff
+type RotationConfig struct {
+	Name    string         `json:"name"`
+	Members []OnCallMember `json:"members"`
+	Start   time.Time      `json:"start"`  // like 2021-01-04T10:00:00+08:00, handoffs happen at the same time every period
+	Period  Duration       `json:"period"` // a week if not positive
+}
+
+// OverrideConfig members on call instead of those of the rotation between start and end
+type OverrideConfig struct {
+	Rotation string         `json:"rotation"` // name of the rotation, all rotations if empty
+	Members  []OnCallMember `json:"members"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+}
+
+// ScheduleConfig on-call schedule, members on call of every rotation are at when alarms are sent
+type ScheduleConfig struct {
+	Name      string            `json:"name"`
+	Rotations []*RotationConfig `json:"rotations"`
+	Overrides []*OverrideConfig `json:"overrides"`
+}
+
+const defaultRotationPeriod = 7 * 24 * time.Hour
+
+func (member OnCallMember) check() error {
+	if member.Mobile == "" && member.UserID == "" {
+		return fmt.Errorf("member %s needs mobile or userId", member.Name)
+	}
+
+	return nil
+}
+
+func (schedule *ScheduleConfig) compile() error {
+	if schedule.Name == "" {
+		return fmt.Errorf("schedule name is required")
+	}
+
+	if len(schedule.Rotations) == 0 {
+		return fmt.Errorf("schedule needs at least one rotation")
+	}
+
+	rotations := make(map[string]bool)
+	for _, rotation := range schedule.Rotations {
+		if len(rotation.Members) == 0 {
+			return fmt.Errorf("rotation %s needs at least one member", rotation.Name)
+		}
+		for _, member := range rotation.Members {
+			if err := member.check(); err != nil {
+				return err
+			}
+		}
+		if rotation.Start.IsZero() {
+			return fmt.Errorf("rotation %s start is required", rotation.Name)
+		}
+		if rotation.Period <= 0 {
+			rotation.Period = Duration(defaultRotationPeriod)
+		}
+		rotations[rotation.Name] = true
+	}
+
+	for _, override := range schedule.Overrides {
+		if override.Rotation != "" && !rotations[override.Rotation] {
+			return fmt.Errorf("rotation %s of override is not found", override.Rotation)
+		}
+		for _, member := range override.Members {
+			if err := member.check(); err != nil {
+				return err
+			}
+		}
+		if !override.End.After(override.Start) {
+			return fmt.Errorf("override should end after it starts")
+		}
+	}
+
+	return nil
+}
+
+// onCall member of the rotation on call at the time
+func (rotation *RotationConfig) onCall(now time.Time) OnCallMember {
+	period := time.Duration(rotation.Period)
+	turns := int64(now.Sub(rotation.Start) / period)
+	if now.Before(rotation.Start) && now.Sub(rotation.Start)%period != 0 {
+		turns--
+	}
+
+	count := int64(len(rotation.Members))
+	return rotation.Members[(turns%count+count)%count]
+}
+
+// onCall members on call at the time, overrides go first
+func (schedule *ScheduleConfig) onCall(now time.Time) []OnCallMember {
+	var members []OnCallMember
+	for _, rotation := range schedule.Rotations {
+		overridden := false
+		for _, override := range schedule.Overrides {
+			if (override.Rotation == "" || override.Rotation == rotation.Name) &&
+				!now.Before(override.Start) && now.Before(override.End) {
+				members = append(members, override.Members...)
+				overridden = true
+				break
+			}
+		}
+
+		if !overridden {
+			members = append(members, rotation.onCall(now))
+		}
+	}
+
+	return members
+}
+
+// compileSchedules check schedules and index them by name
+func compileSchedules(schedules []*ScheduleConfig) (map[string]*ScheduleConfig, error) {
+	compiled := make(map[string]*ScheduleConfig, len(schedules))
+	for _, schedule := range schedules {
+		err := schedule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s is invalid: %v", schedule.Name, err)
+		}
+
+		if compiled[schedule.Name] != nil {
+			return nil, fmt.Errorf("schedule %s is duplicated", schedule.Name)
+		}
+		compiled[schedule.Name] = schedule
+	}
+
+	return compiled, nil
+}
+
+// checkOnCall whether the schedule exists
+func (filter *MsgFilterConfig) checkOnCall(name string) error {
+	if name != "" && filter.schedules[name] == nil {
+		return fmt.Errorf("schedule %s is not found", name)
+	}
+
+	return nil
+}
+
+// onCallAt mobiles and user ids of members on call of the schedule at the time, none if no schedule
+func (filter *MsgFilterConfig) onCallAt(name string, now time.Time) ([]string, []string) {
+	schedule := filter.schedules[name]
+	if name == "" || schedule == nil {
+		return nil, nil
+	}
+
+	var mobiles, userIds []string
+	for _, member := range schedule.onCall(now) {
+		if member.Mobile != "" {
+			mobiles = appendUnique(mobiles, member.Mobile)
+		}
+		if member.UserID != "" {
+			userIds = appendUnique(userIds, member.UserID)
+		}
+	}
+
+	return mobiles, userIds
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationOnCall(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	week := 7 * 24 * time.Hour
+	rotation := &RotationConfig{
+		Name:    "ops",
+		Members: []OnCallMember{{Name: "a", Mobile: "1"}, {Name: "b", Mobile: "2"}, {Name: "c", Mobile: "3"}},
+		Start:   start,
+	}
+	schedule := &ScheduleConfig{Name: "ops", Rotations: []*RotationConfig{rotation}}
+	if err := schedule.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{"first handoff", start, "a"},
+		{"end of first turn", start.Add(week - time.Nanosecond), "a"},
+		{"second handoff", start.Add(week), "b"},
+		{"third turn", start.Add(2*week + time.Hour), "c"},
+		{"back to first", start.Add(3 * week), "a"},
+		{"far future", start.Add(100*week + time.Minute), "b"},
+		{"just before start", start.Add(-time.Nanosecond), "c"},
+		{"a period before start", start.Add(-week), "c"},
+		{"just before a period before start", start.Add(-week - time.Nanosecond), "b"},
+		{"other timezone", start.Add(week).UTC(), "b"},
+	}
+
+	for _, test := range tests {
+		if got := rotation.onCall(test.now); got.Name != test.want {
+			t.Errorf("%s: %s on call, want %s", test.name, got.Name, test.want)
+		}
+	}
+
+	rotation.Period = Duration(12 * time.Hour)
+	if got := rotation.onCall(start.Add(13 * time.Hour)); got.Name != "b" {
+		t.Errorf("period of 12h: %s on call, want b", got.Name)
+	}
+}
+
+func TestScheduleOnCallOverride(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+	schedule := &ScheduleConfig{
+		Name: "game",
+		Rotations: []*RotationConfig{
+			{Name: "server", Members: []OnCallMember{{Name: "a", Mobile: "1"}, {Name: "b", Mobile: "2"}}, Start: start},
+			{Name: "client", Members: []OnCallMember{{Name: "c", Mobile: "3"}}, Start: start},
+		},
+		Overrides: []*OverrideConfig{
+			{Rotation: "server", Members: []OnCallMember{{Name: "d", Mobile: "4"}}, Start: start.Add(time.Hour),
+				End: start.Add(2 * time.Hour)},
+			{Members: []OnCallMember{{Name: "e", Mobile: "5"}}, Start: start.Add(2 * time.Hour),
+				End: start.Add(3 * time.Hour)},
+		},
+	}
+	if err := schedule.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		now  time.Time
+		want []string
+	}{
+		{start, []string{"a", "c"}},
+		{start.Add(time.Hour), []string{"d", "c"}},
+		{start.Add(2 * time.Hour), []string{"e", "e"}},
+		{start.Add(3 * time.Hour), []string{"a", "c"}},
+	}
+
+	for _, test := range tests {
+		members := schedule.onCall(test.now)
+		if len(members) != len(test.want) {
+			t.Fatalf("%s: %v on call, want %v", test.now, members, test.want)
+		}
+		for i := range members {
+			if members[i].Name != test.want[i] {
+				t.Errorf("%s: %v on call, want %v", test.now, members, test.want)
+				break
+			}
+		}
+	}
+}
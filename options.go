@@ -8,8 +8,15 @@ type Options struct {
 
 	ConsumerOpts             []string      `flag:"consumer-opt"`
 	MaxInFlight              int           `flag:"max-in-flight"`
+	MaxAttempts              int           `flag:"max-attempts"`
+	RequeueDelay             time.Duration `flag:"requeue-delay"`
+	MaxRequeueDelay          time.Duration `flag:"max-requeue-delay"`
+	DeadLetterTopic          string        `flag:"dead-letter-topic"`
+	DeadLetterNsqdTCPAddress string        `flag:"dead-letter-nsqd-tcp-address"`
 	HTTPClientConnectTimeout time.Duration `flag:"http-client-connect-timeout"`
 	HTTPClientRequestTimeout time.Duration `flag:"http-client-request-timeout"`
+	HTTPAddress              string        `flag:"http-address"`
+	HTTPToken                string        `flag:"http-token"`
 
 	LogPrefix string `flag:"log-prefix"`
 	LogLevel  string `flag:"log-level"`
@@ -17,6 +24,14 @@ type Options struct {
 	WorkDir   string `flag:"work-dir"`
 	// DatetimeFormat string        `flag:"datetime-format"`
 	SyncInterval time.Duration `flag:"sync-interval"`
+
+	PublisherNum             int           `flag:"publisher-num"`
+	PublisherQueueSize       int           `flag:"publisher-queue-size"`
+	PublisherOverflow        string        `flag:"publisher-overflow"`
+	PublisherMaxRetries      int           `flag:"publisher-max-retries"`
+	PublisherRetryBackoff    time.Duration `flag:"publisher-retry-backoff"`
+	PublisherMaxRetryBackoff time.Duration `flag:"publisher-max-retry-backoff"`
+	PublisherRateLimitWait   time.Duration `flag:"publisher-rate-limit-wait"`
 }
 
 // NewOptions make Options
@@ -26,9 +41,19 @@ func NewOptions() *Options {
 		LogLevel:                 "info",
 		Channel:                  "nsqToDingDing",
 		MaxInFlight:              200,
+		MaxAttempts:              5,
+		RequeueDelay:             time.Second,
+		MaxRequeueDelay:          10 * time.Minute,
 		OutputDir:                "/tmp",
 		SyncInterval:             30 * time.Second,
 		HTTPClientConnectTimeout: 2 * time.Second,
 		HTTPClientRequestTimeout: 5 * time.Second,
+		PublisherNum:             10,
+		PublisherQueueSize:       1000,
+		PublisherOverflow:        OverflowBlock,
+		PublisherMaxRetries:      3,
+		PublisherRetryBackoff:    time.Second,
+		PublisherMaxRetryBackoff: 30 * time.Second,
+		PublisherRateLimitWait:   time.Minute,
 	}
 }
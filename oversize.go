@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// policies of content over max body bytes
+const (
+	OversizeTruncate = "truncate"
+	OversizeSplit    = "split"
+)
+
+const codeFence = "```"
+
+// maxFenceBytes max bytes of a reopened fence line, longer info strings like ```lua... are dropped
+const maxFenceBytes = 32
+
+func checkOversizePolicy(policy string) error {
+	switch policy {
+	case "", OversizeTruncate, OversizeSplit:
+		return nil
+	}
+
+	return fmt.Errorf("unknown oversize policy %s, use %s or %s", policy, OversizeTruncate, OversizeSplit)
+}
+
+// fitContent make the content fit in max bytes by truncating or splitting, not positive max bytes means no limit,
+// content which needs more than max parts is truncated instead, not positive max parts means no limit
+func fitContent(content string, maxBytes int, policy string, maxParts int) []string {
+	if maxBytes <= 0 || len(content) <= maxBytes {
+		return []string{content}
+	}
+
+	if policy == OversizeSplit {
+		parts := splitContent(content, maxBytes)
+		if maxParts <= 0 || len(parts) <= maxParts {
+			return parts
+		}
+	}
+
+	return []string{truncateContent(content, maxBytes)}
+}
+
+// maxTitleRunes titles are cut on their own, the default title is the whole msg
+const maxTitleRunes = 100
+
+// minContentBudget the least content left when the budget shrinks for what the body adds
+const minContentBudget = 256
+
+// fitTitle title of one line at most max title runes, line breaks and runs of spaces become one space
+func fitTitle(title string) string {
+	return truncateRunes(maxTitleRunes, strings.Join(strings.Fields(title), " "))
+}
+
+// fitBodies fit the content so that every request body rendered from its parts is within max bytes,
+// titles, at tokens and json escaping make a body longer than its content,
+// so the content budget shrinks by the overflow till all bodies fit
+func fitBodies(content string, maxBytes int, policy string, maxParts int,
+	render func(parts []string) ([][]byte, error)) ([][]byte, error) {
+	var bodies [][]byte
+	_, err := fitBudget(content, maxBytes, policy, maxParts, func(parts []string) (int, error) {
+		var err error
+		bodies, err = render(parts)
+		if err != nil {
+			return 0, err
+		}
+
+		return overflowBytes(bodies, maxBytes), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bodies, nil
+}
+
+// fitBudget fit the content from the budget, which shrinks by the overflow of the parts reported by measure
+// till nothing overflows, not positive budget means unlimited
+func fitBudget(content string, budget int, policy string, maxParts int,
+	measure func(parts []string) (int, error)) ([]string, error) {
+	for {
+		parts := fitContent(content, budget, policy, maxParts)
+		overflow, err := measure(parts)
+		if err != nil {
+			return nil, err
+		}
+		if overflow <= 0 || budget <= 0 {
+			return parts, nil
+		}
+
+		if budget <= minContentBudget {
+			return nil, &permanentError{fmt.Errorf("msg can not fit, %d bytes over", overflow)}
+		}
+		// escaping may make the overflow larger than the budget, halve it then
+		if overflow < budget-minContentBudget {
+			budget -= overflow
+			continue
+		}
+		budget /= 2
+		if budget < minContentBudget {
+			budget = minContentBudget
+		}
+	}
+}
+
+// overflowBytes bytes of the longest body over max bytes
+func overflowBytes(bodies [][]byte, maxBytes int) int {
+	if maxBytes <= 0 {
+		return 0
+	}
+
+	overflow := 0
+	for _, body := range bodies {
+		if over := len(body) - maxBytes; over > overflow {
+			overflow = over
+		}
+	}
+
+	return overflow
+}
+
+// runeStart the nearest utf8 rune start at or before i
+func runeStart(s string, i int) int {
+	if i >= len(s) {
+		return len(s)
+	}
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+
+	return i
+}
+
+// openFence the opening fence line(like ```lua) if s ends inside a code fence
+func openFence(s string) (string, bool) {
+	if strings.Count(s, codeFence)%2 == 0 {
+		return "", false
+	}
+
+	start := strings.LastIndex(s, codeFence)
+	end := strings.IndexByte(s[start:], '\n')
+	if end < 0 {
+		return s[start:], true
+	}
+
+	return s[start : start+end], true
+}
+
+// reopenFence the fence line to reopen a broken code fence, no longer than max fence bytes
+func reopenFence(fence string) string {
+	if len(fence) > maxFenceBytes {
+		return codeFence
+	}
+
+	return fence
+}
+
+// cutEnd end of the cut at or before i, not in a utf8 rune or a run of backticks, at least one rune is cut
+func cutEnd(s string, i int) int {
+	end := runeStart(s, i)
+	for end > 0 && end < len(s) && s[end] == '`' && s[end-1] == '`' {
+		end--
+	}
+	if end == 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		end = size
+	}
+
+	return end
+}
+
+// closeFence close the code fence on its own line
+func closeFence(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s + codeFence
+	}
+
+	return s + "\n" + codeFence
+}
+
+// truncateContent keep the head and the tail, code fences broken by the cut are closed and reopened
+func truncateContent(content string, maxBytes int) string {
+	// room for the omitted marker, and closing and reopening fences
+	reserve := len("\n\n...(9999999999 bytes omitted)...\n\n") + 2*(maxFenceBytes+len(codeFence)+2)
+	budget := maxBytes - reserve
+	if budget <= 0 {
+		return content[:runeStart(content, maxBytes)]
+	}
+
+	headEnd := cutEnd(content, budget*2/3)
+	tailStart := runeStart(content, len(content)-(budget-headEnd))
+	for tailStart < len(content) && tailStart > 0 && content[tailStart] == '`' && content[tailStart-1] == '`' {
+		tailStart++
+	}
+	if tailStart < headEnd {
+		tailStart = headEnd
+	}
+	head, tail := content[:headEnd], content[tailStart:]
+
+	if _, ok := openFence(head); ok {
+		head = closeFence(head)
+	}
+	result := head + fmt.Sprintf("\n\n...(%d bytes omitted)...\n\n", tailStart-headEnd)
+	if fence, ok := openFence(content[:tailStart]); ok {
+		result += reopenFence(fence) + "\n"
+	}
+	result += tail
+	if _, ok := openFence(result); ok {
+		result = closeFence(result)
+	}
+
+	return result
+}
+
+// splitContent split the content into numbered parts like (1/3),
+// a code fence broken by the split is closed and reopened in the next part
+func splitContent(content string, maxBytes int) []string {
+	reserve := len("(999/999)\n") + 2*(maxFenceBytes+len(codeFence)+2)
+	budget := maxBytes - reserve
+	if budget <= 0 {
+		return []string{content[:runeStart(content, maxBytes)]}
+	}
+
+	var chunks []string
+	for rest, reopen := content, ""; rest != ""; {
+		size := budget - len(reopen)
+		end := len(rest)
+		if end > size {
+			end = cutEnd(rest, size)
+			// prefer to split at a line end in the last fifth
+			if newline := strings.LastIndexByte(rest[:end], '\n'); newline > end*4/5 {
+				end = newline + 1
+			}
+		}
+
+		chunk := reopen + rest[:end]
+		rest = rest[end:]
+		reopen = ""
+		if fence, ok := openFence(chunk); ok && rest != "" {
+			chunk = closeFence(chunk)
+			reopen = reopenFence(fence) + "\n"
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	parts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(chunks), chunk)
+	}
+
+	return parts
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitContent(t *testing.T) {
+	longFence := "```" + strings.Repeat("y", 3000) + "\n```lua\nabc\n```\n"
+	luaStack := "error in lua\n```lua\n" + strings.Repeat("stack traceback: in function <foo.lua:12>\n", 200) + "```\ndone"
+	chinese := strings.Repeat("错误信息", 800)
+	backticks := strings.Repeat("a``b", 600)
+
+	tests := []struct {
+		name     string
+		content  string
+		maxBytes int
+		policy   string
+		maxParts int
+		parts    int // expected parts, 0 means more than one
+	}{
+		{"fit", "short", 100, OversizeSplit, 0, 1},
+		{"no limit", chinese, 0, OversizeTruncate, 0, 1},
+		{"truncate plain", strings.Repeat("x", 5000), 1000, OversizeTruncate, 0, 1},
+		{"split plain", strings.Repeat("x\n", 2500), 1000, OversizeSplit, 0, 0},
+		{"truncate fence", luaStack, 1000, OversizeTruncate, 0, 1},
+		{"split fence", luaStack, 1000, OversizeSplit, 0, 0},
+		{"truncate long fence line", longFence, 1000, OversizeTruncate, 0, 1},
+		{"split long fence line", longFence, 1000, OversizeSplit, 0, 0},
+		{"truncate runes", chinese, 1000, OversizeTruncate, 0, 1},
+		{"split runes", chinese, 1000, OversizeSplit, 0, 0},
+		{"split backticks", backticks, 500, OversizeSplit, 0, 0},
+		{"truncate backticks", backticks, 500, OversizeTruncate, 0, 1},
+		{"tiny max", luaStack, 50, OversizeSplit, 0, 1},
+		{"split within max parts", strings.Repeat("x\n", 1000), 1000, OversizeSplit, 3, 3},
+		{"split over max parts", strings.Repeat("x\n", 2500), 1000, OversizeSplit, 3, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := fitContent(test.content, test.maxBytes, test.policy, test.maxParts)
+			if test.parts > 0 && len(parts) != test.parts {
+				t.Fatalf("got %d parts, want %d", len(parts), test.parts)
+			}
+			if test.parts == 0 && len(parts) < 2 {
+				t.Fatalf("got %d parts, want more than one", len(parts))
+			}
+
+			balanced := strings.Count(test.content, codeFence)%2 == 0
+			for i, part := range parts {
+				if test.maxBytes > 0 && len(part) > test.maxBytes {
+					t.Errorf("part %d has %d bytes over %d", i+1, len(part), test.maxBytes)
+				}
+				if !utf8.ValidString(part) {
+					t.Errorf("part %d is not valid utf8", i+1)
+				}
+				if balanced && test.maxBytes >= 200 && strings.Count(part, codeFence)%2 != 0 {
+					t.Errorf("part %d has unbalanced fences: %q", i+1, part)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateLogBodiesWithinMaxBodyBytes(t *testing.T) {
+	for _, schema := range []string{SchemaText, SchemaMarkdown} {
+		for _, policy := range []string{OversizeTruncate, OversizeSplit} {
+			filter := newNsqToDingDingConfig().Filter
+			filter.MaxBodyBytes = 1000
+			filter.OversizePolicy = policy
+			filter.MaxSplitParts = 0
+			if err := filter.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			data := sampleTemplateData()
+			data.Msg = strings.Repeat("in function <foo.lua:12> \"quoted\"\n", 200)
+			logData := data.LogDataInfo
+			logData.AtMobiles = []string{"13800000000", "13900000000"}
+			bodies, err := generateLogBodies(newMsgStyle(filter, schema, nil), filter, logData, data)
+			if err != nil {
+				t.Fatalf("%s %s: %v", schema, policy, err)
+			}
+			if policy == OversizeSplit && len(bodies) < 2 {
+				t.Errorf("%s %s: got %d bodies, want more than one", schema, policy, len(bodies))
+			}
+			for i, body := range bodies {
+				if len(body) > filter.MaxBodyBytes {
+					t.Errorf("%s %s: body %d has %d bytes", schema, policy, i+1, len(body))
+				}
+				if !strings.Contains(string(body), "@13900000000") {
+					t.Errorf("%s %s: body %d lost mentions", schema, policy, i+1)
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateMarkdownSplitParts(t *testing.T) {
+	filter := newNsqToDingDingConfig().Filter
+	filter.MaxBodyBytes = 1000
+	filter.OversizePolicy = OversizeSplit
+	filter.MaxSplitParts = 0
+	if err := filter.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := sampleTemplateData()
+	data.Msg = "first line\n" + strings.Repeat("in function <foo.lua:12>\n", 100)
+	bodies, err := generateLogBodies(newMsgStyle(filter, SchemaMarkdown, nil), filter, data.LogDataInfo, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) < 2 {
+		t.Fatalf("got %d bodies, want more than one", len(bodies))
+	}
+
+	for i, body := range bodies {
+		var req DingDingReqBodyInfo
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatal(err)
+		}
+		number := fmt.Sprintf("(%d/%d)", i+1, len(bodies))
+		if strings.Count(req.Markdown.Title+req.Markdown.Text, number) != 1 {
+			t.Errorf("body %d is not numbered once: title %q", i+1, req.Markdown.Title)
+		}
+		if strings.ContainsAny(req.Markdown.Title, "\r\n") {
+			t.Errorf("body %d title has line breaks: %q", i+1, req.Markdown.Title)
+		}
+	}
+
+	filter.MaxSplitParts = 2
+	bodies, err = generateLogBodies(newMsgStyle(filter, SchemaMarkdown, nil), filter, data.LogDataInfo, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 || !strings.Contains(string(bodies[0]), "bytes omitted") {
+		t.Errorf("over max split parts: got %d bodies, want one truncated", len(bodies))
+	}
+}
+
+func TestGenerateFeedCardWithinMaxBodyBytes(t *testing.T) {
+	tests := []struct {
+		name       string
+		messageURL string
+		ok         bool
+	}{
+		{"long title", "https://example.com/log", true},
+		{"long url", "https://example.com/log?msg={{.Msg}}", false},
+	}
+
+	for _, test := range tests {
+		filter := newNsqToDingDingConfig().Filter
+		filter.MaxBodyBytes = 1000
+		filter.FeedCard = &FeedCardConfig{Links: []*FeedCardLinkConfig{{Title: "{{.Msg}}", MessageURL: test.messageURL}}}
+		if err := filter.compile(); err != nil {
+			t.Fatal(err)
+		}
+
+		data := sampleTemplateData()
+		data.Msg = strings.Repeat("in function <foo.lua:12>\n", 100)
+		bodies, err := generateLogBodies(newMsgStyle(filter, SchemaFeedCard, nil), filter, data.LogDataInfo, data)
+		if test.ok != (err == nil) {
+			t.Fatalf("%s: error %v", test.name, err)
+		}
+		if err != nil {
+			if isRetryable(err) {
+				t.Errorf("%s: error %v is retryable", test.name, err)
+			}
+			continue
+		}
+		if len(bodies) != 1 || len(bodies[0]) > filter.MaxBodyBytes {
+			t.Errorf("%s: got %d bodies of %d bytes", test.name, len(bodies), len(bodies[0]))
+		}
+	}
+}
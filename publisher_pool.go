@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// overflow policies when the publisher queue is full
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+)
+
+// errPublisherBusy the queue of drop-newest is full, the message is requeued to nsq instead of being dropped
+var errPublisherBusy = errors.New("publisher queue is full")
+
+// errors of dropped tasks
+var (
+	errPublisherDropOldest = errors.New("dropped as the oldest of full publisher queue")
+	errPublisherClosed     = errors.New("publisher is closed")
+)
+
+// publishTask msgs of one message waiting for a publisher worker,
+// drop is called instead of send when the task is dropped, as its nsq message is already finished
+type publishTask struct {
+	send func()
+	drop func(err error)
+}
+
+// PublisherPool bounded publisher workers fed by a bounded queue, each topic has its own pool,
+// so up to topics * workerNum msgs are sent at the same time
+type PublisherPool struct {
+	queue    chan publishTask
+	overflow string
+	dropped  uint64
+	wg       sync.WaitGroup
+	mutex    sync.RWMutex
+	closed   bool
+}
+
+func checkOverflowPolicy(overflow string) error {
+	switch overflow {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return nil
+	}
+
+	return fmt.Errorf("unknown overflow policy %s, use %s, %s or %s", overflow,
+		OverflowBlock, OverflowDropOldest, OverflowDropNewest)
+}
+
+// NewPublisherPool create PublisherPool and start its workers
+func NewPublisherPool(workerNum, queueSize int, overflow string) (*PublisherPool, error) {
+	if workerNum <= 0 {
+		return nil, fmt.Errorf("publisher num should be positive")
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("publisher queue size should be positive")
+	}
+	if err := checkOverflowPolicy(overflow); err != nil {
+		return nil, err
+	}
+
+	pool := &PublisherPool{
+		queue:    make(chan publishTask, queueSize),
+		overflow: overflow,
+	}
+
+	pool.wg.Add(workerNum)
+	for i := 0; i < workerNum; i++ {
+		go pool.worker()
+	}
+
+	return pool, nil
+}
+
+func (pool *PublisherPool) worker() {
+	defer pool.wg.Done()
+
+	for task := range pool.queue {
+		task.send()
+	}
+}
+
+// dropTask count the dropped task and let it dead-letter its message
+func (pool *PublisherPool) dropTask(task publishTask, err error) {
+	atomic.AddUint64(&pool.dropped, 1)
+	log.Printf("PublisherPool drop message: %v", err)
+	if task.drop != nil {
+		task.drop(err)
+	}
+}
+
+// Dropped number of dropped tasks
+func (pool *PublisherPool) Dropped() uint64 {
+	return atomic.LoadUint64(&pool.dropped)
+}
+
+// shedding the queue of drop-newest is full, so new messages would be dropped
+func (pool *PublisherPool) shedding() bool {
+	return pool.overflow == OverflowDropNewest && len(pool.queue) >= cap(pool.queue)
+}
+
+// submit queue the task, what happens when the queue is full depends on the overflow policy,
+// dropped tasks are counted and dead-lettered
+func (pool *PublisherPool) submit(task publishTask) {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	if pool.closed {
+		pool.dropTask(task, errPublisherClosed)
+		return
+	}
+
+	switch pool.overflow {
+	case OverflowDropNewest:
+		select {
+		case pool.queue <- task:
+		default:
+			pool.dropTask(task, errPublisherBusy)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case pool.queue <- task:
+				return
+			default:
+			}
+
+			select {
+			case oldest := <-pool.queue:
+				pool.dropTask(oldest, errPublisherDropOldest)
+			default:
+			}
+		}
+	default:
+		pool.queue <- task
+	}
+}
+
+// Close stop accepting tasks, and wait for the queued ones to be sent
+func (pool *PublisherPool) Close() {
+	pool.mutex.Lock()
+	if pool.closed {
+		pool.mutex.Unlock()
+		return
+	}
+	pool.closed = true
+	close(pool.queue)
+	pool.mutex.Unlock()
+
+	pool.wg.Wait()
+}
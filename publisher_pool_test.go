@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPublisherPoolDrop(t *testing.T) {
+	tests := []struct {
+		overflow string
+		dropped  []int
+		err      error
+	}{
+		{OverflowDropNewest, []int{2, 3}, errPublisherBusy},
+		{OverflowDropOldest, []int{0, 1}, errPublisherDropOldest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overflow, func(t *testing.T) {
+			pool, err := NewPublisherPool(1, 2, test.overflow)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// hold the only worker so the queue fills up
+			hold, held := make(chan struct{}), make(chan struct{})
+			pool.submit(publishTask{send: func() {
+				close(held)
+				<-hold
+			}})
+			<-held
+
+			var mutex sync.Mutex
+			var dropped []int
+			for i := 0; i < 4; i++ {
+				i := i
+				pool.submit(publishTask{
+					send: func() {},
+					drop: func(err error) {
+						if err != test.err {
+							t.Errorf("task %d dropped by %v, want %v", i, err, test.err)
+						}
+						mutex.Lock()
+						dropped = append(dropped, i)
+						mutex.Unlock()
+					},
+				})
+			}
+			if !pool.shedding() && test.overflow == OverflowDropNewest {
+				t.Errorf("full drop-newest pool is not shedding")
+			}
+
+			close(hold)
+			pool.Close()
+
+			if pool.Dropped() != uint64(len(test.dropped)) {
+				t.Errorf("dropped count %d, want %d", pool.Dropped(), len(test.dropped))
+			}
+			if len(dropped) != len(test.dropped) {
+				t.Fatalf("dropped %v, want %v", dropped, test.dropped)
+			}
+			for i := range dropped {
+				if dropped[i] != test.dropped[i] {
+					t.Fatalf("dropped %v, want %v", dropped, test.dropped)
+				}
+			}
+
+			pool.submit(publishTask{send: func() {}, drop: func(err error) {
+				if err != errPublisherClosed {
+					t.Errorf("submit after close dropped by %v", err)
+				}
+			}})
+		})
+	}
+}
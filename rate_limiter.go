@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket token bucket of one dingding robot, refilled continuously at limit per minute
+type tokenBucket struct {
+	limit  int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit),
+		last:   now,
+	}
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(bucket.last)
+	if elapsed <= 0 {
+		return
+	}
+
+	bucket.tokens += elapsed.Minutes() * float64(bucket.limit)
+	if bucket.tokens > float64(bucket.limit) {
+		bucket.tokens = float64(bucket.limit)
+	}
+	bucket.last = now
+}
+
+// take take one token, or return how long to wait for the next one
+func (bucket *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	bucket.refill(now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / float64(bucket.limit) * float64(time.Minute))
+	return false, wait
+}
+
+// RobotRateLimiter rate limiter per dingding robot,
+// all publishers share one because they share the robots
+type RobotRateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRobotRateLimiter create RobotRateLimiter
+func NewRobotRateLimiter() *RobotRateLimiter {
+	return &RobotRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// take take quota of the robot, limit is messages per minute and not positive means unlimited
+func (limiter *RobotRateLimiter) take(token string, limit int, now time.Time) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	bucket, ok := limiter.buckets[token]
+	if !ok {
+		bucket = newTokenBucket(limit, now)
+		limiter.buckets[token] = bucket
+	} else if bucket.limit != limit {
+		// config changed
+		bucket.refill(now)
+		bucket.limit = limit
+		if bucket.tokens > float64(limit) {
+			bucket.tokens = float64(limit)
+		}
+	}
+
+	return bucket.take(now)
+}
+
+// drain use up the quota of the robot, dingding tells us it is limited
+func (limiter *RobotRateLimiter) drain(token string, now time.Time) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if bucket, ok := limiter.buckets[token]; ok {
+		bucket.refill(now)
+		bucket.tokens = 0
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRobotRateLimiterTake(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+
+	type take struct {
+		token string
+		limit int
+		at    time.Duration // since start
+		ok    bool
+		wait  time.Duration
+	}
+	tests := []struct {
+		name  string
+		takes []take
+		drain string // token drained before the last take
+	}{
+		{"unlimited", []take{{"a", 0, 0, true, 0}, {"a", 0, 0, true, 0}, {"a", -1, 0, true, 0}}, ""},
+		{"burst of limit", []take{{"a", 2, 0, true, 0}, {"a", 2, 0, true, 0}, {"a", 2, 0, false, 30 * time.Second}}, ""},
+		{"refill", []take{{"a", 2, 0, true, 0}, {"a", 2, 0, true, 0}, {"a", 2, 30 * time.Second, true, 0},
+			{"a", 2, 30 * time.Second, false, 30 * time.Second}}, ""},
+		{"partial refill", []take{{"a", 2, 0, true, 0}, {"a", 2, 0, true, 0},
+			{"a", 2, 20 * time.Second, false, 10 * time.Second}}, ""},
+		{"refill no more than limit", []take{{"a", 1, 0, true, 0}, {"a", 1, time.Hour, true, 0},
+			{"a", 1, time.Hour, false, time.Minute}}, ""},
+		{"robots apart", []take{{"a", 1, 0, true, 0}, {"b", 1, 0, true, 0}, {"a", 1, 0, false, time.Minute}}, ""},
+		{"clock going back", []take{{"a", 1, time.Minute, true, 0}, {"a", 1, 0, false, time.Minute}}, ""},
+		{"limit lowered", []take{{"a", 20, 0, true, 0}, {"a", 1, 0, true, 0}, {"a", 1, 0, false, time.Minute}}, ""},
+		{"limit raised", []take{{"a", 1, 0, true, 0}, {"a", 2, 30 * time.Second, false, 15 * time.Second},
+			{"a", 2, 45 * time.Second, true, 0}}, ""},
+		{"drained", []take{{"a", 20, 0, true, 0}, {"a", 20, 0, false, 3 * time.Second}}, "a"},
+		{"drain other robot", []take{{"a", 20, 0, true, 0}, {"a", 20, 0, true, 0}}, "b"},
+	}
+
+	for _, test := range tests {
+		limiter := NewRobotRateLimiter()
+		for i, take := range test.takes {
+			now := start.Add(take.at)
+			if i == len(test.takes)-1 && test.drain != "" {
+				limiter.drain(test.drain, now)
+			}
+			ok, wait := limiter.take(take.token, take.limit, now)
+			if ok != take.ok || wait != take.wait {
+				t.Errorf("%s: take %d got %v %s, want %v %s", test.name, i+1, ok, wait, take.ok, take.wait)
+			}
+		}
+	}
+}
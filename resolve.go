@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// ResolveConfig once the rule alarms, send a resolved msg when no log matches it for quiet period
+type ResolveConfig struct {
+	Quiet  Duration `json:"quiet"`
+	Fields []string `json:"fields"` // fields making up alert key, fields of threshold if empty
+}
+
+func (config *ResolveConfig) compile() error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Quiet <= 0 {
+		return fmt.Errorf("resolve quiet should be positive")
+	}
+
+	return nil
+}
+
+type resolveEntry struct {
+	route   string
+	rule    string
+	m       *nsq.Message
+	logData LogDataInfo
+	first   time.Time
+	last    time.Time
+	quiet   time.Duration
+	count   int
+	timer   *time.Timer
+}
+
+// Resolver track firing alerts of rules and resolve them after quiet period
+type Resolver struct {
+	mutex   sync.Mutex
+	entries map[string]*resolveEntry
+	summary func(route string, m *nsq.Message, logData LogDataInfo, content string)
+}
+
+// NewResolver create Resolver, summary is called when an alert is resolved
+func NewResolver(summary func(route string, m *nsq.Message, logData LogDataInfo, content string)) *Resolver {
+	return &Resolver{
+		entries: make(map[string]*resolveEntry),
+		summary: summary,
+	}
+}
+
+func resolveKey(rule *RuleConfig, route string, logData LogDataInfo) string {
+	fields := rule.Resolve.Fields
+	if len(fields) == 0 && rule.Threshold != nil {
+		fields = rule.Threshold.Fields
+	}
+
+	values := make([]string, 0, len(fields)+2)
+	values = append(values, route, rule.Name)
+	for _, field := range fields {
+		values = append(values, logData.Fields[field])
+	}
+
+	return strings.Join(values, "\x00")
+}
+
+// observe count the log matching the rule at now to the firing alert and put off its resolving,
+// fired means the log alarms, which starts an alert of count occurrences if none is firing
+func (resolver *Resolver) observe(rule *RuleConfig, route string, m *nsq.Message, logData LogDataInfo,
+	fired bool, count int, now time.Time) {
+	if rule.Resolve == nil {
+		return
+	}
+
+	key := resolveKey(rule, route, logData)
+
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	if entry, ok := resolver.entries[key]; ok {
+		entry.count++
+		entry.last = now
+		entry.timer.Reset(entry.quiet)
+		return
+	}
+
+	if !fired {
+		return
+	}
+
+	entry := &resolveEntry{
+		route:   route,
+		rule:    rule.Name,
+		m:       m,
+		logData: logData,
+		first:   now,
+		last:    now,
+		quiet:   time.Duration(rule.Resolve.Quiet),
+		count:   count,
+	}
+	entry.timer = time.AfterFunc(entry.quiet, func() {
+		resolver.resolve(key, entry, time.Now())
+	})
+	resolver.entries[key] = entry
+}
+
+// resolve send resolved msg of the alert if it is quiet at now
+func (resolver *Resolver) resolve(key string, entry *resolveEntry, now time.Time) {
+	resolver.mutex.Lock()
+	if resolver.entries[key] != entry || now.Sub(entry.last) < entry.quiet {
+		// put off by a log coming at the same time
+		resolver.mutex.Unlock()
+		return
+	}
+	delete(resolver.entries, key)
+	count, first, last := entry.count, entry.first, entry.last
+	resolver.mutex.Unlock()
+
+	msg := entry.logData.Msg
+	if index := strings.IndexByte(msg, '\n'); index >= 0 {
+		msg = msg[:index]
+	}
+	content := fmt.Sprintf("[已恢复] %s: %s\n持续 %s 共 %d 次, 自 %s 起 %s 内未再出现", entry.rule,
+		msg, last.Sub(first).Round(time.Second), count, last.Format("2006-01-02 15:04:05"), entry.quiet)
+	resolver.summary(entry.route, entry.m, entry.logData, content)
+}
+
+// Close stop tracking alerts, which are not resolved
+func (resolver *Resolver) Close() {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	for key, entry := range resolver.entries {
+		entry.timer.Stop()
+		delete(resolver.entries, key)
+	}
+}
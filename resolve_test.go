@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+func TestResolverResolve(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+	rule := &RuleConfig{Name: "nil", Resolve: &ResolveConfig{Quiet: Duration(10 * time.Minute)}}
+	logData := LogDataInfo{Msg: "attempt to index nil\nstack traceback", Fields: map[string]string{}}
+
+	type observation struct {
+		fired bool
+		count int
+		at    time.Duration // since start
+	}
+	tests := []struct {
+		name         string
+		observations []observation
+		resolveAt    time.Duration
+		tracked      bool
+		summary      string
+	}{
+		{"not fired", []observation{{false, 1, 0}}, 10 * time.Minute, false, ""},
+		{"still firing", []observation{{true, 5, 0}}, 9 * time.Minute, true, ""},
+		{"resolved", []observation{{true, 5, 0}}, 10 * time.Minute, false,
+			"[已恢复] nil: attempt to index nil\n持续 0s 共 5 次, 自 2021-01-04 10:00:00 起 10m0s 内未再出现"},
+		{"put off", []observation{{true, 5, 0}, {false, 1, 3 * time.Minute}, {false, 2, 5 * time.Minute}},
+			12 * time.Minute, true, ""},
+		{"resolved after put off", []observation{{true, 5, 0}, {false, 1, 3 * time.Minute}, {true, 3, 5 * time.Minute}},
+			15 * time.Minute, false,
+			"[已恢复] nil: attempt to index nil\n持续 5m0s 共 7 次, 自 2021-01-04 10:05:00 起 10m0s 内未再出现"},
+	}
+
+	for _, test := range tests {
+		var summaries []string
+		resolver := NewResolver(func(route string, m *nsq.Message, logData LogDataInfo, content string) {
+			summaries = append(summaries, content)
+		})
+
+		for _, observation := range test.observations {
+			resolver.observe(rule, "r", nil, logData, observation.fired, observation.count,
+				start.Add(observation.at))
+		}
+		key := resolveKey(rule, "r", logData)
+		if entry, ok := resolver.entries[key]; ok {
+			resolver.resolve(key, entry, start.Add(test.resolveAt))
+		}
+
+		if _, ok := resolver.entries[key]; ok != test.tracked {
+			t.Errorf("%s: tracked %v, want %v", test.name, ok, test.tracked)
+		}
+		if test.summary == "" && len(summaries) > 0 {
+			t.Errorf("%s: resolved %v", test.name, summaries)
+		}
+		if test.summary != "" && (len(summaries) != 1 || summaries[0] != test.summary) {
+			t.Errorf("%s: resolved %q, want %q", test.name, summaries, test.summary)
+		}
+		resolver.Close()
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryableError error which knows whether it is worth retrying
+type retryableError interface {
+	Retryable() bool
+}
+
+// permanentError error which should never be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Retryable implement of retryableError
+func (e *permanentError) Retryable() bool {
+	return false
+}
+
+// retryAfterError error which tells how long to wait before retrying, like slack 429 with Retry-After
+type retryAfterError interface {
+	RetryAfter() time.Duration
+}
+
+// retryAfter how long the error asks to wait, 0 if it does not
+func retryAfter(err error) time.Duration {
+	var re retryAfterError
+	if errors.As(err, &re) {
+		return re.RetryAfter()
+	}
+
+	return 0
+}
+
+// parseRetryAfter Retry-After header in seconds, 0 if there is none
+func parseRetryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// isRetryable errors are retryable unless they say otherwise, network errors mostly recover by themselves
+func isRetryable(err error) bool {
+	var re retryableError
+	if errors.As(err, &re) {
+		return re.Retryable()
+	}
+
+	return true
+}
+
+// backoffDuration exponential backoff with jitter for the attempt(start from 0),
+// the result is between half and whole of min(base*2^attempt, max)
+func backoffDuration(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	if max < base {
+		max = base
+	}
+
+	backoff := max
+	if attempt < 32 {
+		if exp := base << uint(attempt); exp > 0 && exp < max {
+			backoff = exp
+		}
+	}
+
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
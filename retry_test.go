@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffDuration(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+		max     time.Duration
+		ceiling time.Duration // result is between half and whole of it
+	}{
+		{0, time.Second, 30 * time.Second, time.Second},
+		{1, time.Second, 30 * time.Second, 2 * time.Second},
+		{3, time.Second, 30 * time.Second, 8 * time.Second},
+		{5, time.Second, 30 * time.Second, 30 * time.Second},
+		{31, time.Second, 30 * time.Second, 30 * time.Second},
+		{40, time.Second, 30 * time.Second, 30 * time.Second},
+		{2, time.Second, 0, time.Second},
+		{0, 0, time.Minute, 0},
+		{3, time.Millisecond, time.Minute, 8 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			backoff := backoffDuration(test.attempt, test.base, test.max)
+			if backoff < test.ceiling/2 || backoff > test.ceiling {
+				t.Errorf("attempt %d base %s max %s: backoff %s not in [%s, %s]", test.attempt, test.base, test.max,
+					backoff, test.ceiling/2, test.ceiling)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryErrors(t *testing.T) {
+	tests := []struct {
+		err        error
+		retryable  bool
+		retryAfter time.Duration
+	}{
+		{errors.New("connection reset"), true, 0},
+		{&permanentError{errors.New("bad request")}, false, 0},
+		{fmt.Errorf("wrapped: %w", &permanentError{errors.New("bad request")}), false, 0},
+		{&deadLetterError{DeadLetterInvalid, errors.New("no message")}, false, 0},
+		{&RobotError{Sink: SinkSlack, StatusCode: http.StatusTooManyRequests, Wait: 3 * time.Second}, true, 3 * time.Second},
+		{fmt.Errorf("wrapped: %w", &RobotError{Sink: SinkWebhook, StatusCode: http.StatusServiceUnavailable,
+			Wait: time.Minute}), true, time.Minute},
+		{&RobotError{Sink: SinkWebhook, StatusCode: http.StatusBadRequest}, false, 0},
+	}
+
+	for _, test := range tests {
+		if isRetryable(test.err) != test.retryable {
+			t.Errorf("%v: retryable %v, want %v", test.err, isRetryable(test.err), test.retryable)
+		}
+		if retryAfter(test.err) != test.retryAfter {
+			t.Errorf("%v: retry after %s, want %s", test.err, retryAfter(test.err), test.retryAfter)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"0", 0},
+		{"-1", 0},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		if test.value != "" {
+			header.Set("Retry-After", test.value)
+		}
+		if got := parseRetryAfter(header); got != test.want {
+			t.Errorf("Retry-After %q: %s, want %s", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RouteConfig route alarms to a named robot group, what the route configures overrides filter config,
+// routes are matched in order and the first matched one is used unless it continues,
+// alarms matching no route go by filter config itself
+type RouteConfig struct {
+	Name     string   `json:"name"`
+	Topics   []string `json:"topics"`   // any topic if empty
+	Match    string   `json:"match"`    // rule expression, see RuleExpr
+	Rules    []string `json:"rules"`    // names of rules, one of them must be the rule matching the alarm
+	Continue bool     `json:"continue"` // keep matching later routes
+
+	Sink         string          `json:"sink"`
+	URL          string          `json:"url"` // default url of the sink if sink changes
+	Protocol     string          `json:"protocol"`
+	TokenSecrets []TokenSecret   `json:"token-secrets"`
+	RateLimit    int             `json:"rateLimit"` // positive one overrides
+	Schema       string          `json:"schema"`
+	AtMobiles    []string        `json:"atMobiles"`
+	AtUserIds    []string        `json:"atUserIds"`
+	OnCall       string          `json:"onCall"`
+	FilterKeys   []string        `json:"filterKeys"`
+	IgnoreKeys   []string        `json:"ignoreKeys"`
+	NotAtKeys    []string        `json:"notAtKeys"`
+	FilterRules  []string        `json:"filterRules"`
+	IgnoreRules  []string        `json:"ignoreRules"`
+	NotAtRules   []string        `json:"notAtRules"`
+	Templates    *TemplateConfig `json:"templates"`
+	Webhook      *WebhookConfig  `json:"webhook"`
+	SMTP         *SMTPConfig     `json:"smtp"`
+	Recipients   []string        `json:"recipients"`
+
+	DigestInterval Duration `json:"digestInterval"` // positive one overrides the interval of digest
+
+	match  *RuleExpr
+	filter *MsgFilterConfig
+}
+
+// checkOwnRobots a route changing sink needs robots or endpoint of its own, those of the parent are for another sink
+func (route *RouteConfig) checkOwnRobots() error {
+	switch route.Sink {
+	case SinkWebhook:
+		if route.URL == "" {
+			return fmt.Errorf("route %s changes sink to %s without url of its own", route.Name, route.Sink)
+		}
+	case SinkSMTP:
+		if route.SMTP == nil {
+			return fmt.Errorf("route %s changes sink to %s without smtp of its own", route.Name, route.Sink)
+		}
+	default:
+		if len(route.TokenSecrets) == 0 {
+			return fmt.Errorf("route %s changes sink to %s without token-secrets of its own", route.Name, route.Sink)
+		}
+	}
+
+	return nil
+}
+
+// compile make the filter config of the route over the compiled parent
+func (route *RouteConfig) compile(parent *MsgFilterConfig) error {
+	if route.Name == "" {
+		return fmt.Errorf("route name is required")
+	}
+
+	var err error
+	if route.Match != "" {
+		route.match, err = CompileRuleExpr(route.Match)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range route.Rules {
+		if parent.rule(name) == nil {
+			return fmt.Errorf("rule %s is not found", name)
+		}
+	}
+
+	filter := *parent
+	filter.Routes = nil
+	filter.routeName = route.Name
+	if route.Sink != "" {
+		err = checkSink(route.Sink)
+		if err != nil {
+			return err
+		}
+		filter.Sink = route.Sink
+		if filter.sink() != parent.sink() {
+			filter.URL = filter.sink().DefaultURL()
+			err = route.checkOwnRobots()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if route.URL != "" {
+		filter.URL = route.URL
+	}
+	if route.Protocol != "" {
+		filter.Protocol = route.Protocol
+	}
+	if len(route.TokenSecrets) > 0 {
+		filter.TokenSecrets = route.TokenSecrets
+	}
+	if route.RateLimit > 0 {
+		filter.RateLimit = route.RateLimit
+	}
+	if route.Webhook != nil {
+		err = route.Webhook.compile()
+		if err != nil {
+			return fmt.Errorf("webhook is invalid: %v", err)
+		}
+		filter.Webhook = route.Webhook
+	}
+	if route.SMTP != nil {
+		err = route.SMTP.compile()
+		if err != nil {
+			return fmt.Errorf("smtp is invalid: %v", err)
+		}
+		filter.SMTP = route.SMTP
+	}
+	if route.Recipients != nil {
+		filter.Recipients = route.Recipients
+	}
+	if route.DigestInterval < 0 {
+		return fmt.Errorf("digestInterval should not be negative")
+	}
+	if route.DigestInterval > 0 {
+		if parent.Digest == nil {
+			return fmt.Errorf("digestInterval needs digest config")
+		}
+		digest := *parent.Digest
+		digest.Interval = route.DigestInterval
+		filter.Digest = &digest
+	}
+	err = checkSinkConfig(&filter)
+	if err != nil {
+		return err
+	}
+	if route.Schema != "" {
+		err = checkSchema(route.Schema)
+		if err != nil {
+			return err
+		}
+		filter.Schema = route.Schema
+	}
+	if route.AtMobiles != nil {
+		filter.AtMobiles = route.AtMobiles
+	}
+	if route.AtUserIds != nil {
+		filter.AtUserIds = route.AtUserIds
+	}
+	if route.OnCall != "" {
+		err = parent.checkOnCall(route.OnCall)
+		if err != nil {
+			return err
+		}
+		filter.OnCall = route.OnCall
+	}
+	if route.FilterKeys != nil {
+		filter.FilterKeys = route.FilterKeys
+	}
+	if route.IgnoreKeys != nil {
+		filter.IgnoreKeys = route.IgnoreKeys
+	}
+	if route.NotAtKeys != nil {
+		filter.NotAtKeys = route.NotAtKeys
+	}
+	if route.FilterRules != nil {
+		filter.FilterRules = route.FilterRules
+		filter.filterRules, err = compileRuleExprs("filterRules", route.FilterRules)
+		if err != nil {
+			return err
+		}
+	}
+	if route.IgnoreRules != nil {
+		filter.IgnoreRules = route.IgnoreRules
+		filter.ignoreRules, err = compileRuleExprs("ignoreRules", route.IgnoreRules)
+		if err != nil {
+			return err
+		}
+	}
+	if route.NotAtRules != nil {
+		filter.NotAtRules = route.NotAtRules
+		filter.notAtRules, err = compileRuleExprs("notAtRules", route.NotAtRules)
+		if err != nil {
+			return err
+		}
+	}
+	if route.Templates != nil {
+		filter.Templates = route.Templates
+		filter.templates, err = compileMsgTemplates(route.Templates)
+		if err != nil {
+			return fmt.Errorf("templates is invalid: %v", err)
+		}
+	}
+
+	route.filter = &filter
+	return nil
+}
+
+// matches whether the alarm of the topic goes by the route, rule is the one matching the alarm
+func (route *RouteConfig) matches(topic string, logData LogDataInfo, rule *RuleConfig) bool {
+	if len(route.Topics) > 0 && !containsString(route.Topics, topic) {
+		return false
+	}
+
+	if len(route.Rules) > 0 && (rule == nil || !containsString(route.Rules, rule.Name)) {
+		return false
+	}
+
+	return route.match == nil || route.match.Match(logData.Fields)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rule the rule of the name, nil if none
+func (filter *MsgFilterConfig) rule(name string) *RuleConfig {
+	for _, rule := range filter.Rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// routeFilters filter configs of the routes which the alarm of the topic goes by,
+// the route of its active time window or its severity if configured
+func (filter *MsgFilterConfig) routeFilters(topic string, logData LogDataInfo) []*MsgFilterConfig {
+	rule := filter.matchRule(logData)
+	for _, window := range filter.activeWindows(logData, rule, time.Now()) {
+		if window.Route != "" {
+			return []*MsgFilterConfig{filter.routeFilter(window.Route)}
+		}
+	}
+
+	if config := filter.severityConfig(logData.Severity); config != nil && config.Route != "" {
+		return []*MsgFilterConfig{filter.routeFilter(config.Route)}
+	}
+
+	var filters []*MsgFilterConfig
+	for _, route := range filter.Routes {
+		if !route.matches(topic, logData, rule) {
+			continue
+		}
+
+		filters = append(filters, route.filter)
+		if !route.Continue {
+			break
+		}
+	}
+
+	if len(filters) == 0 {
+		return []*MsgFilterConfig{filter}
+	}
+
+	return filters
+}
+
+// routeFilter filter config of the route name, the filter itself if the route is gone
+func (filter *MsgFilterConfig) routeFilter(name string) *MsgFilterConfig {
+	for _, route := range filter.Routes {
+		if route.Name == name {
+			return route.filter
+		}
+	}
+
+	return filter
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteCompileChangingSink(t *testing.T) {
+	tokens := []TokenSecret{{Token: "route-token"}}
+	tests := []struct {
+		name  string
+		route RouteConfig
+		err   string
+	}{
+		{"same sink", RouteConfig{Name: "r", Sink: SinkDingDing}, ""},
+		{"feishu without tokens", RouteConfig{Name: "r", Sink: SinkFeishu}, "without token-secrets"},
+		{"wecom without tokens", RouteConfig{Name: "r", Sink: SinkWeCom}, "without token-secrets"},
+		{"slack without tokens", RouteConfig{Name: "r", Sink: SinkSlack}, "without token-secrets"},
+		{"feishu with tokens", RouteConfig{Name: "r", Sink: SinkFeishu, TokenSecrets: tokens}, ""},
+		{"webhook without url", RouteConfig{Name: "r", Sink: SinkWebhook}, "without url"},
+		{"webhook with url", RouteConfig{Name: "r", Sink: SinkWebhook, URL: "example.com/hook"}, ""},
+		{"smtp without smtp", RouteConfig{Name: "r", Sink: SinkSMTP, Recipients: []string{"ops@example.com"}},
+			"without smtp"},
+	}
+
+	for _, test := range tests {
+		filter := newNsqToDingDingConfig().Filter
+		filter.TokenSecrets = []TokenSecret{{Token: "parent-token"}}
+		if err := filter.compile(); err != nil {
+			t.Fatal(err)
+		}
+
+		route := test.route
+		err := route.compile(filter)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %s", test.name, err, test.err)
+		}
+		if err == nil && route.Sink != SinkDingDing && len(route.filter.robots()) > 0 &&
+			route.filter.robots()[0].Token == "parent-token" {
+			t.Errorf("%s: route inherits robots of the parent", test.name)
+		}
+	}
+}
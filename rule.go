@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleConfig rule of alarm msgs, the first rule matching the msg decides how it is sent
+type RuleConfig struct {
+	Name  string   `json:"name"`
+	Keys  []string `json:"keys"`  // msg contains any of the keys
+	Match string   `json:"match"` // rule expression, see RuleExpr
+
+	Severity  string   `json:"severity"`  // severity of matched logs, over severity field
+	AtMobiles []string `json:"atMobiles"` // at them too for matched logs
+	AtUserIds []string `json:"atUserIds"`
+	OnCall    string   `json:"onCall"` // name of the schedule, whose members on call are at too
+
+	Threshold *ThresholdConfig `json:"threshold"` // alarm only when matched logs happen often
+	Resolve   *ResolveConfig   `json:"resolve"`   // send resolved msg when matched logs stop
+
+	Schema     string            `json:"schema"`
+	ActionCard *ActionCardConfig `json:"actionCard"`
+	Link       *LinkConfig       `json:"link"`
+	FeedCard   *FeedCardConfig   `json:"feedCard"`
+
+	match *RuleExpr
+}
+
+// containsAny whether msg contains any of the keys
+func containsAny(msg string, keys []string) bool {
+	for _, key := range keys {
+		if strings.Contains(msg, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches whether the rule matches the log, both keys and match expression must match if they are given
+func (rule *RuleConfig) matches(logData LogDataInfo) bool {
+	if len(rule.Keys) > 0 && !containsAny(logData.Msg, rule.Keys) {
+		return false
+	}
+
+	return rule.match == nil || rule.match.Match(logData.Fields)
+}
+
+func (rule *RuleConfig) compile(sample TemplateData) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+
+	err := checkSchema(rule.Schema)
+	if err != nil {
+		return err
+	}
+
+	err = checkSeverity(rule.Severity)
+	if err != nil {
+		return err
+	}
+
+	if rule.Match != "" {
+		rule.match, err = CompileRuleExpr(rule.Match)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rule.Threshold.compile()
+	if err != nil {
+		return err
+	}
+
+	err = rule.Resolve.compile()
+	if err != nil {
+		return err
+	}
+
+	err = rule.ActionCard.compile(sample)
+	if err != nil {
+		return err
+	}
+
+	err = rule.Link.compile(sample)
+	if err != nil {
+		return err
+	}
+
+	return rule.FeedCard.compile(sample)
+}
+
+// isIgnored whether the log should not be alarmed, only logs matching filter keys or rules are alarmed
+// if any is configured, and logs matching ignore keys or rules are not
+func (filter *MsgFilterConfig) isIgnored(logData LogDataInfo) bool {
+	isIgnore := false
+	if len(filter.FilterKeys) > 0 || len(filter.filterRules) > 0 {
+		isIgnore = !containsAny(logData.Msg, filter.FilterKeys) && !matchAnyExpr(filter.filterRules, logData.Fields)
+	}
+
+	if containsAny(logData.Msg, filter.IgnoreKeys) || matchAnyExpr(filter.ignoreRules, logData.Fields) {
+		isIgnore = true
+	}
+
+	return isIgnore
+}
+
+// matchRule the first rule matching the log, nil if none
+func (filter *MsgFilterConfig) matchRule(logData LogDataInfo) *RuleConfig {
+	for _, rule := range filter.Rules {
+		if rule.matches(logData) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// msgStyle how the msg looks, rule overrides filter config
+type msgStyle struct {
+	schema     string
+	actionCard *ActionCardConfig
+	link       *LinkConfig
+	feedCard   *FeedCardConfig
+}
+
+func newMsgStyle(filter *MsgFilterConfig, schema string, rule *RuleConfig) msgStyle {
+	style := msgStyle{
+		schema:     schema,
+		actionCard: filter.ActionCard,
+		link:       filter.Link,
+		feedCard:   filter.FeedCard,
+	}
+	if rule == nil {
+		return style
+	}
+
+	if rule.Schema != "" {
+		style.schema = rule.Schema
+	}
+	if rule.ActionCard != nil {
+		style.actionCard = rule.ActionCard
+	}
+	if rule.Link != nil {
+		style.link = rule.Link
+	}
+	if rule.FeedCard != nil {
+		style.feedCard = rule.FeedCard
+	}
+
+	return style
+}
+
+// check whether the schema has what it needs
+func (style msgStyle) check() error {
+	switch style.schema {
+	case SchemaActionCard:
+		if style.actionCard == nil || len(style.actionCard.Buttons) == 0 {
+			return fmt.Errorf("schema actionCard needs actionCard buttons")
+		}
+	case SchemaLink:
+		if style.link == nil {
+			return fmt.Errorf("schema link needs link")
+		}
+	case SchemaFeedCard:
+		if style.feedCard == nil || len(style.feedCard.Links) == 0 {
+			return fmt.Errorf("schema feedCard needs feedCard links")
+		}
+	}
+
+	return nil
+}
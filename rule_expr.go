@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RuleExpr compiled boolean expression over extracted fields, like
+//
+//	gamePlatform == "ios" && msg =~ /attempt to index nil/i
+//	!(nodeName contains "test") || message icontains "FATAL"
+//
+// operators: == != =~ !~ contains icontains, combined by && || ! and parentheses,
+// regexp flag i means case insensitive, msg is short for message
+type RuleExpr struct {
+	source string
+	root   exprNode
+}
+
+type exprNode interface {
+	eval(fields map[string]string) bool
+}
+
+type andNode struct{ left, right exprNode }
+type orNode struct{ left, right exprNode }
+type notNode struct{ node exprNode }
+type constNode struct{ value bool }
+
+type compareNode struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (node andNode) eval(fields map[string]string) bool {
+	return node.left.eval(fields) && node.right.eval(fields)
+}
+
+func (node orNode) eval(fields map[string]string) bool {
+	return node.left.eval(fields) || node.right.eval(fields)
+}
+
+func (node notNode) eval(fields map[string]string) bool {
+	return !node.node.eval(fields)
+}
+
+func (node constNode) eval(fields map[string]string) bool {
+	return node.value
+}
+
+func (node compareNode) eval(fields map[string]string) bool {
+	value := fields[node.field]
+	switch node.op {
+	case "==":
+		return value == node.value
+	case "!=":
+		return value != node.value
+	case "=~":
+		return node.re.MatchString(value)
+	case "!~":
+		return !node.re.MatchString(value)
+	case "contains":
+		return strings.Contains(value, node.value)
+	case "icontains":
+		return strings.Contains(strings.ToLower(value), node.value)
+	}
+
+	return false
+}
+
+// Match whether the fields match the expression
+func (expr *RuleExpr) Match(fields map[string]string) bool {
+	return expr.root.eval(fields)
+}
+
+func (expr *RuleExpr) String() string {
+	return expr.source
+}
+
+// token kinds of rule expression
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenRegexp
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type exprToken struct {
+	kind  int
+	text  string
+	flags string // flags of regexp
+	pos   int
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func tokenizeRuleExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, exprToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, exprToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(source) && source[end] != '"'; end++ {
+				if source[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unclosed string at %d", i)
+			}
+			text, err := strconv.Unquote(source[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", i, err)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: text, pos: i})
+			i = end + 1
+		case c == '/':
+			var builder strings.Builder
+			end := i + 1
+			for ; end < len(source) && source[end] != '/'; end++ {
+				if source[end] == '\\' && end+1 < len(source) {
+					// only \/ is unescaped, others belong to regexp
+					if source[end+1] != '/' {
+						builder.WriteByte('\\')
+					}
+					end++
+				}
+				builder.WriteByte(source[end])
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unclosed regexp at %d", i)
+			}
+			flagsEnd := end + 1
+			for flagsEnd < len(source) && isIdentByte(source[flagsEnd]) {
+				flagsEnd++
+			}
+			tokens = append(tokens, exprToken{kind: tokenRegexp, text: builder.String(),
+				flags: source[end+1 : flagsEnd], pos: i})
+			i = flagsEnd
+		case strings.HasPrefix(source[i:], "&&") || strings.HasPrefix(source[i:], "||") ||
+			strings.HasPrefix(source[i:], "==") || strings.HasPrefix(source[i:], "!=") ||
+			strings.HasPrefix(source[i:], "=~") || strings.HasPrefix(source[i:], "!~"):
+			tokens = append(tokens, exprToken{kind: tokenOp, text: source[i : i+2], pos: i})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, exprToken{kind: tokenOp, text: "!", pos: i})
+			i++
+		case isIdentByte(c):
+			end := i
+			for end < len(source) && isIdentByte(source[end]) {
+				end++
+			}
+			word := source[i:end]
+			kind := tokenIdent
+			if word == "contains" || word == "icontains" {
+				kind = tokenOp
+			}
+			tokens = append(tokens, exprToken{kind: kind, text: word, pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+
+	return append(tokens, exprToken{kind: tokenEOF, pos: len(source)}), nil
+}
+
+type ruleExprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (parser *ruleExprParser) peek() exprToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *ruleExprParser) next() exprToken {
+	token := parser.tokens[parser.pos]
+	if token.kind != tokenEOF {
+		parser.pos++
+	}
+
+	return token
+}
+
+func (parser *ruleExprParser) parseOr() (exprNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for token := parser.peek(); token.kind == tokenOp && token.text == "||"; token = parser.peek() {
+		parser.next()
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (parser *ruleExprParser) parseAnd() (exprNode, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for token := parser.peek(); token.kind == tokenOp && token.text == "&&"; token = parser.peek() {
+		parser.next()
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (parser *ruleExprParser) parseUnary() (exprNode, error) {
+	token := parser.peek()
+	if token.kind == tokenOp && token.text == "!" {
+		parser.next()
+		node, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *ruleExprParser) parsePrimary() (exprNode, error) {
+	token := parser.next()
+	switch token.kind {
+	case tokenLParen:
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := parser.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expect ) at %d", closing.pos)
+		}
+		return node, nil
+	case tokenIdent:
+		if token.text == "true" || token.text == "false" {
+			return constNode{token.text == "true"}, nil
+		}
+		return parser.parseCompare(token)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", token.text, token.pos)
+}
+
+func (parser *ruleExprParser) parseCompare(field exprToken) (exprNode, error) {
+	name := field.text
+	if name == "msg" {
+		name = FieldMessage
+	}
+
+	op := parser.next()
+	if op.kind != tokenOp || op.text == "&&" || op.text == "||" || op.text == "!" {
+		return nil, fmt.Errorf("expect operator after %s at %d", field.text, op.pos)
+	}
+
+	value := parser.next()
+	switch op.text {
+	case "=~", "!~":
+		if value.kind != tokenRegexp {
+			return nil, fmt.Errorf("expect /regexp/ after %s at %d", op.text, value.pos)
+		}
+		pattern := value.text
+		for _, flag := range value.flags {
+			if flag != 'i' && flag != 's' && flag != 'm' {
+				return nil, fmt.Errorf("unknown regexp flag %c at %d", flag, value.pos)
+			}
+		}
+		if value.flags != "" {
+			pattern = "(?" + value.flags + ")" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp at %d: %v", value.pos, err)
+		}
+		return compareNode{field: name, op: op.text, re: re}, nil
+	default:
+		if value.kind != tokenString {
+			return nil, fmt.Errorf("expect \"string\" after %s at %d", op.text, value.pos)
+		}
+		text := value.text
+		if op.text == "icontains" {
+			text = strings.ToLower(text)
+		}
+		return compareNode{field: name, op: op.text, value: text}, nil
+	}
+}
+
+// CompileRuleExpr compile the rule expression, the error tells where it is wrong
+func CompileRuleExpr(source string) (*RuleExpr, error) {
+	tokens, err := tokenizeRuleExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: %v", source, err)
+	}
+
+	parser := &ruleExprParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: %v", source, err)
+	}
+
+	if token := parser.peek(); token.kind != tokenEOF {
+		return nil, fmt.Errorf("rule %q: unexpected %q at %d", source, token.text, token.pos)
+	}
+
+	return &RuleExpr{source: source, root: root}, nil
+}
+
+// compileRuleExprs compile a list of rule expressions
+func compileRuleExprs(name string, sources []string) ([]*RuleExpr, error) {
+	exprs := make([]*RuleExpr, 0, len(sources))
+	for i, source := range sources {
+		expr, err := CompileRuleExpr(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %v", name, i, err)
+		}
+		exprs = append(exprs, expr)
+	}
+
+	return exprs, nil
+}
+
+// matchAnyExpr whether any expression matches the fields
+func matchAnyExpr(exprs []*RuleExpr, fields map[string]string) bool {
+	for _, expr := range exprs {
+		if expr.Match(fields) {
+			return true
+		}
+	}
+
+	return false
+}
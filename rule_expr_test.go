@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleExprMatch(t *testing.T) {
+	fields := map[string]string{
+		"gamePlatform": "ios",
+		"nodeName":     "game1",
+		"fileName":     "/var/log/game/error.log",
+		"message":      "FATAL: attempt to index nil value",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`gamePlatform == "ios"`, true},
+		{`gamePlatform != "ios"`, false},
+		{`msg =~ /attempt to index nil/`, true},
+		{`msg =~ /ATTEMPT to index/`, false},
+		{`msg =~ /ATTEMPT to index/i`, true},
+		{`msg !~ /timeout/`, true},
+		{`fileName =~ /^\/var\/log\//`, true},
+		{`message contains "index nil"`, true},
+		{`message contains "fatal"`, false},
+		{`message icontains "fatal"`, true},
+		{`missing == ""`, true},
+		{`gamePlatform == "ios" && nodeName == "game2"`, false},
+		{`gamePlatform == "ios" || nodeName == "game2"`, true},
+		{`!(nodeName contains "test")`, true},
+		{`!nodeName contains "game"`, false},
+		{`gamePlatform == "android" && nodeName == "game1" || msg icontains "fatal"`, true},
+		{`gamePlatform == "android" && (nodeName == "game1" || msg icontains "fatal")`, false},
+		{`true && !false`, true},
+		{`msg == "say \"hi\""`, false},
+	}
+
+	for _, test := range tests {
+		expr, err := CompileRuleExpr(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := expr.Match(fields); got != test.want {
+			t.Errorf("%s: match %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCompileRuleExprError(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{``, "unexpected end"},
+		{`msg`, "expect operator after msg"},
+		{`msg ==`, "expect \"string\" after =="},
+		{`msg == /x/`, "expect \"string\" after =="},
+		{`msg =~ "x"`, "expect /regexp/ after =~"},
+		{`msg =~ /x/g`, "unknown regexp flag g"},
+		{`msg =~ /(x/`, "invalid regexp at 7"},
+		{`msg == "x`, "unclosed string at 7"},
+		{`msg =~ /x`, "unclosed regexp at 7"},
+		{`(msg == "x"`, "expect ) at 11"},
+		{`msg == "x")`, "unexpected \")\" at 10"},
+		{`msg == "x" &&`, "unexpected end"},
+		{`msg == "x" $`, "unexpected '$' at 11"},
+	}
+
+	for _, test := range tests {
+		_, err := CompileRuleExpr(test.expr)
+		if err == nil {
+			t.Errorf("%s: want error", test.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %s", test.expr, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// severities of alarms, from the most severe
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// severityAliases levels which logs use for the severities
+var severityAliases = map[string]string{
+	"critical": SeverityCritical,
+	"crit":     SeverityCritical,
+	"fatal":    SeverityCritical,
+	"panic":    SeverityCritical,
+	"error":    SeverityError,
+	"err":      SeverityError,
+	"warning":  SeverityWarning,
+	"warn":     SeverityWarning,
+	"info":     SeverityInfo,
+	"notice":   SeverityInfo,
+	"debug":    SeverityInfo,
+}
+
+// parseSeverity severity of the level, empty if unknown
+func parseSeverity(level string) string {
+	return severityAliases[strings.ToLower(strings.TrimSpace(level))]
+}
+
+func checkSeverity(severity string) error {
+	if severity != "" && parseSeverity(severity) != severity {
+		return fmt.Errorf("unknown severity %s, should be critical, error, warning or info", severity)
+	}
+
+	return nil
+}
+
+// SeverityConfig how alarms of a severity are sent, what is not configured uses the default
+type SeverityConfig struct {
+	AtAll     *bool    `json:"atAll"`     // critical at all and warning, info do not by default, error as before
+	AtMobiles []string `json:"atMobiles"` // replace atMobiles of filter config
+	AtUserIds []string `json:"atUserIds"` // replace atUserIds of filter config
+	OnCall    string   `json:"onCall"`    // replace onCall of filter config
+	Route     string   `json:"route"`     // name of the route which alarms go by instead of matched routes
+	Marker    string   `json:"marker"`    // put before title and content
+}
+
+func newBool(value bool) *bool {
+	return &value
+}
+
+var defaultSeverityConfigs = map[string]SeverityConfig{
+	SeverityCritical: {AtAll: newBool(true), Marker: "🔴[CRITICAL]"},
+	SeverityError:    {Marker: "🟠[ERROR]"},
+	SeverityWarning:  {AtAll: newBool(false), Marker: "🟡[WARNING]"},
+	SeverityInfo:     {AtAll: newBool(false), Marker: "🔵[INFO]"},
+}
+
+// compileSeverities merge severity configs over the defaults
+func compileSeverities(configs map[string]*SeverityConfig) (map[string]*SeverityConfig, error) {
+	severities := make(map[string]*SeverityConfig, len(defaultSeverityConfigs))
+	for severity, config := range defaultSeverityConfigs {
+		config := config
+		severities[severity] = &config
+	}
+
+	for severity, config := range configs {
+		if severity == "" || checkSeverity(severity) != nil {
+			return nil, fmt.Errorf("unknown severity %s, should be critical, error, warning or info", severity)
+		}
+		if config == nil {
+			continue
+		}
+
+		merged := severities[severity]
+		if config.AtAll != nil {
+			merged.AtAll = config.AtAll
+		}
+		if config.AtMobiles != nil {
+			merged.AtMobiles = config.AtMobiles
+		}
+		if config.AtUserIds != nil {
+			merged.AtUserIds = config.AtUserIds
+		}
+		if config.OnCall != "" {
+			merged.OnCall = config.OnCall
+		}
+		if config.Marker != "" {
+			merged.Marker = config.Marker
+		}
+		merged.Route = config.Route
+	}
+
+	return severities, nil
+}
+
+// classify severity of the log, the matched rule decides first, then severity field, then default severity
+func (filter *MsgFilterConfig) classify(logData LogDataInfo, rule *RuleConfig) string {
+	if rule != nil && rule.Severity != "" {
+		return rule.Severity
+	}
+
+	if filter.SeverityField != "" {
+		if severity := parseSeverity(logData.Fields[filter.SeverityField]); severity != "" {
+			return severity
+		}
+	}
+
+	return filter.DefaultSeverity
+}
+
+// severityConfig config of the severity, nil if the log is not classified
+func (filter *MsgFilterConfig) severityConfig(severity string) *SeverityConfig {
+	if severity == "" {
+		return nil
+	}
+
+	return filter.severities[severity]
+}
+
+// mention whether to at all and who to at, by severity of the log if it is classified,
+// people of the matched rule and those on call now are at too, logs matching not at keys or rules never at all
+func (filter *MsgFilterConfig) mention(logData LogDataInfo, rule *RuleConfig) DingDingReqAtInfo {
+	at := DingDingReqAtInfo{
+		AtMobiles: filter.AtMobiles,
+		AtUserIds: filter.AtUserIds,
+	}
+	onCall := filter.OnCall
+	config := filter.severityConfig(logData.Severity)
+	if config != nil && config.AtMobiles != nil {
+		at.AtMobiles = config.AtMobiles
+	}
+	if config != nil && config.AtUserIds != nil {
+		at.AtUserIds = config.AtUserIds
+	}
+	if config != nil && config.OnCall != "" {
+		onCall = config.OnCall
+	}
+
+	now := time.Now()
+	mobiles, userIds := filter.onCallAt(onCall, now)
+	at.AtMobiles = appendUnique(at.AtMobiles, mobiles...)
+	at.AtUserIds = appendUnique(at.AtUserIds, userIds...)
+	if rule != nil {
+		at.AtMobiles = appendUnique(at.AtMobiles, rule.AtMobiles...)
+		at.AtUserIds = appendUnique(at.AtUserIds, rule.AtUserIds...)
+		mobiles, userIds = filter.onCallAt(rule.OnCall, now)
+		at.AtMobiles = appendUnique(at.AtMobiles, mobiles...)
+		at.AtUserIds = appendUnique(at.AtUserIds, userIds...)
+	}
+
+	if containsAny(logData.Msg, filter.NotAtKeys) || matchAnyExpr(filter.notAtRules, logData.Fields) {
+		return at
+	}
+
+	if config != nil && config.AtAll != nil {
+		at.IsAtAll = *config.AtAll
+		return at
+	}
+
+	at.IsAtAll = len(at.AtMobiles) == 0 && len(at.AtUserIds) == 0
+	return at
+}
+
+// appendUnique append values which are not in list yet, list is not modified
+func appendUnique(list []string, values ...string) []string {
+	if len(values) == 0 {
+		return list
+	}
+
+	result := append([]string(nil), list...)
+	for _, value := range values {
+		if !containsString(result, value) {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// marker visual marker of the severity, empty if the log is not classified
+func (filter *MsgFilterConfig) marker(severity string) string {
+	config := filter.severityConfig(severity)
+	if config == nil {
+		return ""
+	}
+
+	return config.Marker
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// silenceRetention how long silences are kept in etcd after they end
+const silenceRetention = 24 * time.Hour
+
+var errSilenceNotFound = errors.New("silence is not found")
+
+// SilenceMatcher matcher on a field of logs, field topic is the topic of logs unless logs have it
+type SilenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"` // value is a regexp which matches the whole field
+
+	re *regexp.Regexp
+}
+
+// parseSilenceMatcher parse matcher like nodeName=game1 or nodeName=~game[0-9]+
+func parseSilenceMatcher(text string) (SilenceMatcher, error) {
+	var matcher SilenceMatcher
+	index := strings.IndexByte(text, '=')
+	if index <= 0 {
+		return matcher, fmt.Errorf("matcher %q should be name=value or name=~regexp", text)
+	}
+
+	matcher.Name = strings.TrimSpace(text[:index])
+	matcher.Value = text[index+1:]
+	if strings.HasPrefix(matcher.Value, "~") {
+		matcher.Value = matcher.Value[1:]
+		matcher.IsRegex = true
+	}
+
+	return matcher, matcher.compile()
+}
+
+func (matcher *SilenceMatcher) compile() error {
+	if matcher.Name == "" {
+		return fmt.Errorf("matcher name is required")
+	}
+
+	if matcher.IsRegex {
+		re, err := regexp.Compile("^(?:" + matcher.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("matcher %s regexp is invalid: %v", matcher.Name, err)
+		}
+		matcher.re = re
+	}
+
+	return nil
+}
+
+func (matcher *SilenceMatcher) matches(topic string, fields map[string]string) bool {
+	value, ok := fields[matcher.Name]
+	if !ok && matcher.Name == "topic" {
+		value = topic
+	}
+
+	if matcher.IsRegex {
+		return matcher.re.MatchString(value)
+	}
+
+	return value == matcher.Value
+}
+
+func (matcher SilenceMatcher) String() string {
+	if matcher.IsRegex {
+		return matcher.Name + "=~" + matcher.Value
+	}
+
+	return matcher.Name + "=" + matcher.Value
+}
+
+// Silence mute alarms matching all matchers between starts at and ends at
+type Silence struct {
+	ID        string           `json:"id"`
+	Matchers  []SilenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+func (silence *Silence) compile() error {
+	if len(silence.Matchers) == 0 {
+		return fmt.Errorf("silence needs at least one matcher")
+	}
+
+	for i := range silence.Matchers {
+		err := silence.Matchers[i].compile()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return fmt.Errorf("silence should end after it starts")
+	}
+
+	return nil
+}
+
+// active whether the silence mutes alarms at the time
+func (silence *Silence) active(now time.Time) bool {
+	return !now.Before(silence.StartsAt) && now.Before(silence.EndsAt)
+}
+
+// matches whether all matchers match the log of the topic
+func (silence *Silence) matches(topic string, fields map[string]string) bool {
+	for i := range silence.Matchers {
+		if !silence.Matchers[i].matches(topic, fields) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SilenceStore silences stored in etcd under etcd path/silences/, kept in memory by watching,
+// nil SilenceStore silences nothing
+type SilenceStore struct {
+	etcdCli  *clientv3.Client
+	prefix   string
+	mutex    sync.RWMutex
+	silences map[string]*Silence
+	watcher  clientv3.Watcher
+	cancel   context.CancelFunc
+}
+
+// NewSilenceStore create SilenceStore of the etcd config path
+func NewSilenceStore(etcdCli *clientv3.Client, etcdPath string) *SilenceStore {
+	return &SilenceStore{
+		etcdCli:  etcdCli,
+		prefix:   strings.TrimRight(etcdPath, "/") + "/silences/",
+		silences: make(map[string]*Silence),
+	}
+}
+
+func newSilenceID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func decodeSilence(value []byte) (*Silence, error) {
+	silence := &Silence{}
+	err := json.Unmarshal(value, silence)
+	if err != nil {
+		return nil, err
+	}
+
+	return silence, silence.compile()
+}
+
+// silenceReloadInterval interval to retry loading silences when the watch is broken
+const silenceReloadInterval = 5 * time.Second
+
+// start load silences and keep them up to date
+func (store *SilenceStore) start() error {
+	rev, err := store.load()
+	if err != nil {
+		return err
+	}
+
+	var ctx context.Context
+	ctx, store.cancel = context.WithCancel(context.Background())
+	store.watcher = clientv3.NewWatcher(store.etcdCli)
+	go store.watch(ctx, rev)
+
+	return nil
+}
+
+// load replace silences by those in etcd, return the revision to watch from
+func (store *SilenceStore) load() (int64, error) {
+	resp, err := store.etcdCli.Get(context.Background(), store.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	silences := make(map[string]*Silence, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		silence, err := decodeSilence(kv.Value)
+		if err != nil {
+			log.Printf("SilenceStore skip invalid silence %s: %v", string(kv.Key), err)
+			continue
+		}
+		silences[string(kv.Key)] = silence
+	}
+
+	store.mutex.Lock()
+	store.silences = silences
+	store.mutex.Unlock()
+
+	return resp.Header.Revision + 1, nil
+}
+
+// watch apply changes of silences from the revision, when the watch fails or the revision is compacted,
+// silences are loaded again and watched from the new revision till the store is closed
+func (store *SilenceStore) watch(ctx context.Context, rev int64) {
+	for {
+		err := store.watchFrom(ctx, rev)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("SilenceStore watch is broken, load silences again: %v", err)
+
+		for {
+			rev, err = store.load()
+			if err == nil {
+				break
+			}
+			log.Printf("SilenceStore load fail, retry after %s: %v", silenceReloadInterval, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(silenceReloadInterval):
+			}
+		}
+	}
+}
+
+// watchFrom apply changes of silences from the revision till the watch fails
+func (store *SilenceStore) watchFrom(ctx context.Context, rev int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for resp := range store.watcher.Watch(ctx, store.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev)) {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+
+		store.mutex.Lock()
+		for _, ev := range resp.Events {
+			key := string(ev.Kv.Key)
+			if ev.Type == clientv3.EventTypeDelete {
+				delete(store.silences, key)
+				continue
+			}
+
+			silence, err := decodeSilence(ev.Kv.Value)
+			if err != nil {
+				log.Printf("SilenceStore skip invalid silence %s: %v", key, err)
+				continue
+			}
+			store.silences[key] = silence
+		}
+		store.mutex.Unlock()
+	}
+
+	return errors.New("watch channel is closed")
+}
+
+// silenced the active silence which mutes the log of the topic, nil if none
+func (store *SilenceStore) silenced(topic string, fields map[string]string, now time.Time) *Silence {
+	if store == nil {
+		return nil
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, silence := range store.silences {
+		if silence.active(now) && silence.matches(topic, fields) {
+			return silence
+		}
+	}
+
+	return nil
+}
+
+// put save the silence, it is removed from etcd after retention since it ends
+func (store *SilenceStore) put(silence *Silence) error {
+	value, err := json.Marshal(silence)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(silence.EndsAt) + silenceRetention
+	lease, err := store.etcdCli.Grant(context.Background(), int64(ttl/time.Second)+1)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.etcdCli.Put(context.Background(), store.prefix+silence.ID, string(value), clientv3.WithLease(lease.ID))
+	return err
+}
+
+// add create the silence, starts at defaults to now
+func (store *SilenceStore) add(silence *Silence) (*Silence, error) {
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = time.Now()
+	}
+
+	err := silence.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	if !silence.EndsAt.After(time.Now()) {
+		return nil, fmt.Errorf("silence should end in the future")
+	}
+
+	silence.ID, err = newSilenceID()
+	if err != nil {
+		return nil, err
+	}
+
+	return silence, store.put(silence)
+}
+
+// get the silence of the id in etcd
+func (store *SilenceStore) get(id string) (*Silence, error) {
+	resp, err := store.etcdCli.Get(context.Background(), store.prefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("%w: %s", errSilenceNotFound, id)
+	}
+
+	return decodeSilence(resp.Kvs[0].Value)
+}
+
+// expire end the silence of the id now
+func (store *SilenceStore) expire(id string) (*Silence, error) {
+	silence, err := store.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !silence.EndsAt.After(now) {
+		return silence, nil
+	}
+
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+	silence.EndsAt = now
+
+	return silence, store.put(silence)
+}
+
+// list silences in etcd, ended ones are included if expired, sorted by ends at
+func (store *SilenceStore) list(expired bool) ([]*Silence, error) {
+	resp, err := store.etcdCli.Get(context.Background(), store.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	silences := make([]*Silence, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		silence, err := decodeSilence(kv.Value)
+		if err != nil {
+			log.Printf("SilenceStore skip invalid silence %s: %v", string(kv.Key), err)
+			continue
+		}
+
+		if expired || silence.EndsAt.After(now) {
+			silences = append(silences, silence)
+		}
+	}
+
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].EndsAt.Before(silences[j].EndsAt)
+	})
+
+	return silences, nil
+}
+
+// Close stop watching silences
+func (store *SilenceStore) Close() {
+	if store == nil || store.watcher == nil {
+		return
+	}
+
+	store.cancel()
+	store.watcher.Close()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const silenceUsage = `usage: nsq_to_dingding [etcd flags] silence <command> [args]
+
+commands:
+  add -matcher name=value [-matcher name=~regexp ...] -duration 2h [-created-by who] [-comment why]
+  expire <id> [<id> ...]
+  list [-expired]`
+
+// runSilenceCommand manage silences in etcd from command line
+func runSilenceCommand(store *SilenceStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(silenceUsage)
+	}
+
+	switch args[0] {
+	case "add":
+		return addSilenceCommand(store, args[1:])
+	case "expire":
+		if len(args) < 2 {
+			return fmt.Errorf(silenceUsage)
+		}
+		for _, id := range args[1:] {
+			silence, err := store.expire(id)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("silence %s expired at %s\n", silence.ID, silence.EndsAt.Format(time.RFC3339))
+		}
+		return nil
+	case "list":
+		fs := flag.NewFlagSet("silence list", flag.ExitOnError)
+		expired := fs.Bool("expired", false, "include ended silences")
+		_ = fs.Parse(args[1:])
+
+		silences, err := store.list(*expired)
+		if err != nil {
+			return err
+		}
+		printSilences(silences)
+		return nil
+	}
+
+	return fmt.Errorf("unknown silence command %s\n%s", args[0], silenceUsage)
+}
+
+func addSilenceCommand(store *SilenceStore, args []string) error {
+	fs := flag.NewFlagSet("silence add", flag.ExitOnError)
+	matchers := ArrayFlags{}
+	fs.Var(&matchers, "matcher", "name=value or name=~regexp on log fields or topic (may be given multiple times)")
+	duration := fs.Duration("duration", time.Hour, "how long the silence lasts")
+	createdBy := fs.String("created-by", os.Getenv("USER"), "who creates the silence")
+	comment := fs.String("comment", "", "why the silence is created")
+	_ = fs.Parse(args)
+
+	silence := &Silence{
+		StartsAt:  time.Now(),
+		CreatedBy: *createdBy,
+		Comment:   *comment,
+	}
+	silence.EndsAt = silence.StartsAt.Add(*duration)
+	for _, text := range matchers {
+		matcher, err := parseSilenceMatcher(text)
+		if err != nil {
+			return err
+		}
+		silence.Matchers = append(silence.Matchers, matcher)
+	}
+
+	silence, err := store.add(silence)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("silence %s added until %s\n", silence.ID, silence.EndsAt.Format(time.RFC3339))
+	return nil
+}
+
+func printSilences(silences []*Silence) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMATCHERS\tSTARTS\tENDS\tCREATED BY\tCOMMENT")
+	for _, silence := range silences {
+		matchers := make([]string, 0, len(silence.Matchers))
+		for _, matcher := range silence.Matchers {
+			matchers = append(matchers, matcher.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", silence.ID, strings.Join(matchers, ","),
+			silence.StartsAt.Format(time.RFC3339), silence.EndsAt.Format(time.RFC3339), silence.CreatedBy, silence.Comment)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// names of sinks
+const (
+	SinkDingDing = "dingding"
+	SinkFeishu   = "feishu"
+	SinkWeCom    = "wecom"
+	SinkSlack    = "slack"
+	SinkWebhook  = "webhook"
+	SinkSMTP     = "smtp"
+)
+
+// Sink where alarm msgs go, like dingding or feishu robots, it renders msgs into request bodies,
+// which DingDingPublisher sends by robots of TokenSecrets, or to the endpoint of endpointSink, with rate limit and retries
+type Sink interface {
+	// Render request bodies of the alarm in the style, content over max body bytes is truncated or split
+	Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo, data TemplateData) ([][]byte, error)
+	// RenderText request bodies of plain text, like summaries and alarms of bad messages
+	RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error)
+	// Send send the request body by the robot once, the robot is zero for endpoint sinks,
+	// the deadline of ctx covers the whole request
+	Send(ctx context.Context, filter *MsgFilterConfig, body []byte, robot TokenSecret) error
+	// DefaultURL url without protocol when filter config has none
+	DefaultURL() string
+}
+
+// sinkClient http client of sinks, the timeout of each request comes from ctx of Send
+var sinkClient = &http.Client{}
+
+// setupSinkClient set the transport of sinkClient by options before any msg is sent
+func setupSinkClient(opts *Options) {
+	sinkClient.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   opts.HTTPClientConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: opts.HTTPClientRequestTimeout,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+var sinks = map[string]Sink{
+	SinkDingDing: DingDingSink{},
+	SinkFeishu:   FeishuSink{},
+	SinkWeCom:    WeComSink{},
+	SinkSlack:    SlackSink{},
+	SinkWebhook:  WebhookSink{},
+	SinkSMTP:     SMTPSink{},
+}
+
+func checkSink(name string) error {
+	if _, ok := sinks[name]; name != "" && !ok {
+		return fmt.Errorf("unknown sink %s", name)
+	}
+
+	return nil
+}
+
+// sink the sink of the filter config, dingding if empty
+func (filter *MsgFilterConfig) sink() Sink {
+	if sink, ok := sinks[filter.Sink]; ok {
+		return sink
+	}
+
+	return sinks[SinkDingDing]
+}
+
+// configuredSink sink which needs config of its own
+type configuredSink interface {
+	// check whether the filter config has what the sink needs
+	check(filter *MsgFilterConfig) error
+}
+
+// checkSinkConfig check what the sink of the filter config needs
+func checkSinkConfig(filter *MsgFilterConfig) error {
+	if sink, ok := filter.sink().(configuredSink); ok {
+		return sink.check(filter)
+	}
+
+	return nil
+}
+
+// endpointSink sink which sends to the endpoint of its config, like a webhook url or a mail server,
+// rather than robots of token secrets
+type endpointSink interface {
+	// rateKey key of the endpoint which rate limit goes by
+	rateKey(filter *MsgFilterConfig) string
+}
+
+// robots robots which msgs of the filter config are sent by, endpoint sinks have only a zero one
+func (filter *MsgFilterConfig) robots() []TokenSecret {
+	if _, ok := filter.sink().(endpointSink); ok {
+		return []TokenSecret{{}}
+	}
+
+	return filter.TokenSecrets
+}
+
+// rateKey key of rate limit of the robot, which is its token or the endpoint of endpoint sinks
+func (filter *MsgFilterConfig) rateKey(robot TokenSecret) string {
+	if sink, ok := filter.sink().(endpointSink); ok {
+		return sink.rateKey(filter)
+	}
+
+	return robot.Token
+}
+
+// sourceTextSink sink whose text msgs take template data of the source message, like its topic and raw json
+type sourceTextSink interface {
+	// renderSourceText RenderText with the template data of the source message
+	renderSourceText(filter *MsgFilterConfig, logData LogDataInfo, content string, source TemplateData) ([][]byte, error)
+}
+
+// renderText request bodies of plain text by the sink, source is template data of the message the text is about
+func (filter *MsgFilterConfig) renderText(logData LogDataInfo, content string, source TemplateData) ([][]byte, error) {
+	if sink, ok := filter.sink().(sourceTextSink); ok {
+		return sink.renderSourceText(filter, logData, content, source)
+	}
+
+	return filter.sink().RenderText(filter, logData, content)
+}
+
+// rateLimitSink sink whose robots have a rate limit of their own
+type rateLimitSink interface {
+	// MaxRateLimit messages per minute of each robot
+	MaxRateLimit() int
+}
+
+// rateLimit messages per minute of each robot of the filter config, no more than that of the sink
+func (filter *MsgFilterConfig) rateLimit() int {
+	sink, ok := filter.sink().(rateLimitSink)
+	if !ok {
+		return filter.RateLimit
+	}
+
+	if limit := sink.MaxRateLimit(); filter.RateLimit <= 0 || filter.RateLimit > limit {
+		return limit
+	}
+
+	return filter.RateLimit
+}
+
+// sinkLink link of card buttons, sinks which know no dingding cards render them their own way
+type sinkLink struct {
+	title string
+	url   string
+}
+
+// styleLinks links of actionCard, link and feedCard of the style
+func styleLinks(style msgStyle, data TemplateData) []sinkLink {
+	var links []sinkLink
+	switch style.schema {
+	case SchemaActionCard:
+		for _, button := range style.actionCard.Buttons {
+			links = append(links, sinkLink{renderTemplate(button.title, data), renderTemplate(button.url, data)})
+		}
+	case SchemaLink:
+		links = append(links, sinkLink{"详情", renderTemplate(style.link.messageURL, data)})
+	case SchemaFeedCard:
+		for _, link := range style.feedCard.Links {
+			links = append(links, sinkLink{renderTemplate(link.title, data), renderTemplate(link.messageURL, data)})
+		}
+	}
+
+	return links
+}
+
+// rateLimitedError error which tells the robot sends too fast
+type rateLimitedError interface {
+	RateLimited() bool
+}
+
+// isRateLimited whether the robot sends too fast
+func isRateLimited(err error) bool {
+	var re rateLimitedError
+	return errors.As(err, &re) && re.RateLimited()
+}
+
+// robotErrorCodes codes of robot responses which are system busy or rate limited, others like
+// bad token, signature or keywords(dingding 310000) and bad key or content(wecom 93000, 40058) never recover
+var robotErrorCodes = map[string]struct{ busy, limited []int }{
+	SinkDingDing: {busy: []int{-1}, limited: []int{130101, 410100}},
+	SinkFeishu:   {limited: []int{9499, 11232}},
+	SinkWeCom:    {busy: []int{-1}, limited: []int{45009}},
+}
+
+// RobotError error answered by the robot or endpoint of a sink, by http status or code of the response
+type RobotError struct {
+	Sink       string
+	StatusCode int
+	Code       int // code of the response, 0 if it has none
+	Msg        string
+	Wait       time.Duration // Retry-After of 429 or 503
+}
+
+func (e *RobotError) Error() string {
+	return fmt.Sprintf("%s fail, status:%d code:%d msg:%s", e.Sink, e.StatusCode, e.Code, e.Msg)
+}
+
+// Retryable only server, timeout, busy and rate limited errors recover by retrying
+func (e *RobotError) Retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusRequestTimeout ||
+		containsInt(robotErrorCodes[e.Sink].busy, e.Code) || e.RateLimited()
+}
+
+// RateLimited the robot sends too fast
+func (e *RobotError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests || containsInt(robotErrorCodes[e.Sink].limited, e.Code)
+}
+
+// RetryAfter implement of retryAfterError
+func (e *RobotError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postJSON request the url of the sink once with the json body, content type can be overridden by headers,
+// the response body is returned, a RobotError if the status is not 2xx
+func postJSON(ctx context.Context, sink, method, url string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &permanentError{err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &RobotError{
+			Sink:       sink,
+			StatusCode: resp.StatusCode,
+			Msg:        string(respBody),
+			Wait:       parseRetryAfter(resp.Header),
+		}
+	}
+
+	return respBody, nil
+}
+
+// RobotRespInfo response of dingding and wecom robots
+type RobotRespInfo struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// checkErrCode check errcode of the robot response, which is 0 on success
+func checkErrCode(sink string, respBody []byte) error {
+	var respInfo RobotRespInfo
+	err := json.Unmarshal(respBody, &respInfo)
+	if err != nil {
+		return &RobotError{Sink: sink, StatusCode: http.StatusOK, Msg: string(respBody)}
+	}
+
+	if respInfo.ErrCode != 0 {
+		return &RobotError{Sink: sink, StatusCode: http.StatusOK, Code: respInfo.ErrCode, Msg: respInfo.ErrMsg}
+	}
+
+	return nil
+}
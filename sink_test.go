@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSinkSendErrors(t *testing.T) {
+	tests := []struct {
+		sink        string
+		status      int
+		body        string
+		ok          bool
+		retryable   bool
+		rateLimited bool
+	}{
+		{SinkDingDing, http.StatusOK, `{"errcode":0,"errmsg":"ok"}`, true, false, false},
+		{SinkDingDing, http.StatusOK, `{"errcode":310000,"errmsg":"keywords not in content"}`, false, false, false},
+		{SinkDingDing, http.StatusOK, `{"errcode":130101,"errmsg":"send too fast"}`, false, true, true},
+		{SinkDingDing, http.StatusOK, `{"errcode":-1,"errmsg":"system busy"}`, false, true, false},
+		{SinkDingDing, http.StatusOK, `not json`, false, false, false},
+		{SinkDingDing, http.StatusBadGateway, `bad gateway`, false, true, false},
+		{SinkWeCom, http.StatusOK, `{"errcode":45009,"errmsg":"api freq out of limit"}`, false, true, true},
+		{SinkWeCom, http.StatusOK, `{"errcode":93000,"errmsg":"invalid webhook url"}`, false, false, false},
+		{SinkFeishu, http.StatusOK, `{"code":0,"msg":"success"}`, true, false, false},
+		{SinkFeishu, http.StatusOK, `{"code":9499,"msg":"too many request"}`, false, true, true},
+		{SinkFeishu, http.StatusOK, `{"StatusCode":19021,"StatusMessage":"sign match fail"}`, false, false, false},
+		{SinkSlack, http.StatusOK, `ok`, true, false, false},
+		{SinkSlack, http.StatusNotFound, `no_service`, false, false, false},
+		{SinkSlack, http.StatusTooManyRequests, `rate_limited`, false, true, true},
+		{SinkWebhook, http.StatusAccepted, ``, true, false, false},
+		{SinkWebhook, http.StatusRequestTimeout, ``, false, true, false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s: content type %s", test.sink, r.Header.Get("Content-Type"))
+			}
+			if test.status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "3")
+			}
+			w.WriteHeader(test.status)
+			_, _ = w.Write([]byte(test.body))
+		}))
+
+		filter := &MsgFilterConfig{Sink: test.sink, Protocol: "http", URL: strings.TrimPrefix(server.URL, "http://")}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := filter.sink().Send(ctx, filter, []byte(`{}`), TokenSecret{Token: "token"})
+		cancel()
+		server.Close()
+
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s %s: %v", test.sink, test.body, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s %s: want error", test.sink, test.body)
+			continue
+		}
+		if isRetryable(err) != test.retryable || isRateLimited(err) != test.rateLimited {
+			t.Errorf("%s %s: retryable %v rate limited %v", test.sink, err, isRetryable(err), isRateLimited(err))
+		}
+		if test.status == http.StatusTooManyRequests && retryAfter(err) != 3*time.Second {
+			t.Errorf("%s %s: retry after %s", test.sink, err, retryAfter(err))
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// limits of slack incoming webhooks
+const (
+	slackMaxHeaderRunes  = 150
+	slackMaxSectionBytes = 3000
+	slackRateLimit       = 60
+)
+
+// slackEscaper escape control characters of slack text, code blocks included
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// SlackReqText slack text object, type is plain_text or mrkdwn, it is also an element of context block
+type SlackReqText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackReqButton slack button element of actions block
+type SlackReqButton struct {
+	Type string       `json:"type"`
+	Text SlackReqText `json:"text"`
+	URL  string       `json:"url"`
+}
+
+// SlackReqBlock slack layout block, header, section, context or actions
+type SlackReqBlock struct {
+	Type     string        `json:"type"`
+	Text     *SlackReqText `json:"text,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+// SlackReqBodyInfo slack req body structure, text is the fallback shown in notifications
+type SlackReqBodyInfo struct {
+	Text   string          `json:"text"`
+	Blocks []SlackReqBlock `json:"blocks,omitempty"`
+}
+
+// SlackSink sink of slack incoming webhooks, token is the path after services/ of webhook url and secret is not used,
+// mobiles are at by the slackUsers table of filter config and user ids are slack member ids
+type SlackSink struct{}
+
+// DefaultURL implement of Sink
+func (sink SlackSink) DefaultURL() string {
+	return "hooks.slack.com/services"
+}
+
+// MaxRateLimit implement of rateLimitSink, slack takes about one message per second of each webhook
+func (sink SlackSink) MaxRateLimit() int {
+	return slackRateLimit
+}
+
+// slackMention mentions of slack members, mobiles not in the table are skipped
+func slackMention(filter *MsgFilterConfig, logData LogDataInfo) string {
+	var tokens []string
+	if logData.IsAtAll {
+		tokens = append(tokens, "<!channel>")
+	}
+
+	var members []string
+	for _, mobile := range logData.AtMobiles {
+		if member, ok := filter.SlackUsers[mobile]; ok {
+			members = appendUnique(members, member)
+		}
+	}
+	for _, userID := range logData.AtUserIds {
+		members = appendUnique(members, userID)
+	}
+	for _, member := range members {
+		tokens = append(tokens, fmt.Sprintf("<@%s>", member))
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+func slackMrkdwn(text string) *SlackReqText {
+	return &SlackReqText{Type: "mrkdwn", Text: text}
+}
+
+// slackHeader header of platform and node with the severity marker
+func slackHeader(data TemplateData) string {
+	header := fmt.Sprintf("%s %s", data.GamePlatform, data.NodeName)
+	if data.Marker != "" {
+		header = data.Marker + " " + header
+	}
+
+	return strings.TrimSpace(header)
+}
+
+// slackContext context line of machine, file and rate
+func slackContext(data TemplateData) string {
+	context := fmt.Sprintf("*machine:* %s   *file:* %s", slackEscaper.Replace(data.MachineName),
+		slackEscaper.Replace(data.FileName))
+	if data.Rate != "" {
+		context += "   *rate:* " + slackEscaper.Replace(data.Rate)
+	}
+
+	return context
+}
+
+// Render implement of Sink, text schema goes as plain text and others as block kit of header,
+// code block of the msg and context, buttons of dingding cards become actions
+func (sink SlackSink) Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo,
+	data TemplateData) ([][]byte, error) {
+	if style.schema == SchemaText {
+		return sink.RenderText(filter, logData, filter.templates.renderText(data))
+	}
+
+	var actions []interface{}
+	for _, link := range styleLinks(style, data) {
+		actions = append(actions, SlackReqButton{
+			Type: "button",
+			Text: SlackReqText{Type: "plain_text", Text: truncateRunes(75, link.title)},
+			URL:  link.url,
+		})
+	}
+
+	header := slackHeader(data)
+	mention := slackMention(filter, logData)
+
+	// the section limit counts the escaped msg, so parts are fit by it rather than their raw length
+	var bodies [][]byte
+	_, err := fitBudget(data.Msg, slackMaxSectionBytes-len(slackCodeBlock("")), filter.OversizePolicy, filter.MaxSplitParts,
+		func(parts []string) (int, error) {
+			bodies = make([][]byte, 0, len(parts))
+			overflow := 0
+			for _, part := range parts {
+				section := slackCodeBlock(slackEscaper.Replace(part))
+				if over := len(section) - slackMaxSectionBytes; over > overflow {
+					overflow = over
+				}
+
+				body, err := slackBlocksBody(truncateRunes(slackMaxHeaderRunes, header), section, data, actions, mention)
+				if err != nil {
+					return 0, err
+				}
+				bodies = append(bodies, body)
+			}
+
+			if over := overflowBytes(bodies, filter.MaxBodyBytes); over > overflow {
+				overflow = over
+			}
+			return overflow, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return bodies, nil
+}
+
+// slackCodeBlock code block of escaped text
+func slackCodeBlock(text string) string {
+	return "```\n" + text + "\n```"
+}
+
+// slackBlocksBody body of header, section of the code block, context, actions and mentions
+func slackBlocksBody(header, section string, data TemplateData, actions []interface{}, mention string) ([]byte, error) {
+	blocks := []SlackReqBlock{
+		{Type: "header", Text: &SlackReqText{Type: "plain_text", Text: header}},
+		{Type: "section", Text: slackMrkdwn(section)},
+		{Type: "context", Elements: []interface{}{slackMrkdwn(slackContext(data))}},
+	}
+	if len(actions) > 0 {
+		blocks = append(blocks, SlackReqBlock{Type: "actions", Elements: actions})
+	}
+	if mention != "" {
+		blocks = append(blocks, SlackReqBlock{Type: "section", Text: slackMrkdwn(mention)})
+	}
+
+	return json.Marshal(SlackReqBodyInfo{
+		Text:   slackEscaper.Replace(header),
+		Blocks: blocks,
+	})
+}
+
+// RenderText implement of Sink, plain text with mentions at the end
+func (sink SlackSink) RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error) {
+	mention := slackMention(filter, logData)
+
+	return fitBodies(content, filter.MaxBodyBytes, filter.OversizePolicy, filter.MaxSplitParts, func(parts []string) ([][]byte, error) {
+		bodies := make([][]byte, 0, len(parts))
+		for _, part := range parts {
+			text := slackEscaper.Replace(part)
+			if mention != "" {
+				text += "\n" + mention
+			}
+			body, err := json.Marshal(SlackReqBodyInfo{Text: text})
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, body)
+		}
+		return bodies, nil
+	})
+}
+
+// Send implement of Sink, slack answers ok or the error like invalid_payload in plain text with the status
+func (sink SlackSink) Send(ctx context.Context, filter *MsgFilterConfig, reqBodyJSON []byte,
+	tokenSecret TokenSecret) error {
+	_, err := postJSON(ctx, SinkSlack, http.MethodPost, fmt.Sprintf("%s://%s/%s", filter.Protocol,
+		strings.TrimRight(filter.URL, "/"), strings.TrimLeft(tokenSecret.Token, "/")), nil, reqBodyJSON)
+	return err
+}
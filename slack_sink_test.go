@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSlackRenderEscapedSection(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          string
+		maxBodyBytes int
+		policy       string
+	}{
+		{"truncate escaped", strings.Repeat("a<b>&c\n", 1000), 0, OversizeTruncate},
+		{"split escaped", strings.Repeat("a<b>&c\n", 1000), 0, OversizeSplit},
+		{"split all escaped", strings.Repeat("<", 5000), 0, OversizeSplit},
+		{"split within max body bytes", strings.Repeat("if a < b && c > d\n", 300), 2000, OversizeSplit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := newNsqToDingDingConfig().Filter
+			filter.Sink = SinkSlack
+			filter.MaxBodyBytes = test.maxBodyBytes
+			filter.OversizePolicy = test.policy
+			filter.MaxSplitParts = 0
+			if err := filter.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			data := sampleTemplateData()
+			data.Msg = test.msg
+			bodies, err := SlackSink{}.Render(newMsgStyle(filter, SchemaMarkdown, nil), filter, data.LogDataInfo, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.policy == OversizeSplit && len(bodies) < 2 {
+				t.Errorf("got %d bodies, want more than one", len(bodies))
+			}
+
+			for i, body := range bodies {
+				if test.maxBodyBytes > 0 && len(body) > test.maxBodyBytes {
+					t.Errorf("body %d has %d bytes", i+1, len(body))
+				}
+
+				var req SlackReqBodyInfo
+				if err := json.Unmarshal(body, &req); err != nil {
+					t.Fatal(err)
+				}
+				section := req.Blocks[1].Text.Text
+				if len(section) > slackMaxSectionBytes {
+					t.Errorf("section of body %d has %d bytes", i+1, len(section))
+				}
+				if strings.ContainsAny(strings.TrimSuffix(strings.TrimPrefix(section, "```\n"), "\n```"), "<>") {
+					t.Errorf("section of body %d is not escaped", i+1)
+				}
+			}
+		})
+	}
+}
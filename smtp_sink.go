@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// tls modes of smtp
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNoTLS    = "none"
+)
+
+// defaultSMTPTimeout timeout of smtp session when ctx has no deadline
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPConfig mail server of smtp sink
+type SMTPConfig struct {
+	Address            string `json:"address"`  // host:port, like smtp.example.com:587
+	TLS                string `json:"tls"`      // starttls(default, port 587), tls(implicit, port 465) or none(local stand-in like mailhog)
+	Username           string `json:"username"` // no auth if empty
+	Password           string `json:"password"`
+	From               string `json:"from"` // like Alarm <alarm@example.com>
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+
+	host string
+	from *mail.Address
+}
+
+func (config *SMTPConfig) compile() error {
+	var err error
+	config.host, _, err = net.SplitHostPort(config.Address)
+	if err != nil {
+		return fmt.Errorf("address %s should be host:port: %v", config.Address, err)
+	}
+
+	switch config.TLS {
+	case "":
+		config.TLS = SMTPStartTLS
+	case SMTPStartTLS, SMTPTLS, SMTPNoTLS:
+	default:
+		return fmt.Errorf("unknown tls %s, should be %s, %s or %s", config.TLS, SMTPStartTLS, SMTPTLS, SMTPNoTLS)
+	}
+
+	if config.Username != "" && config.TLS == SMTPNoTLS && !isLocalHost(config.host) {
+		return fmt.Errorf("auth without tls is only allowed to local server")
+	}
+
+	config.from, err = mail.ParseAddress(config.From)
+	if err != nil {
+		return fmt.Errorf("from %s is invalid: %v", config.From, err)
+	}
+
+	return nil
+}
+
+// isLocalHost local server which plain auth allows without tls
+func isLocalHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// SMTPSink sink of email, msgs are multipart of plain text and html rendered by text and html templates,
+// nobody is at by email
+type SMTPSink struct{}
+
+// DefaultURL implement of Sink, smtp sink uses address of smtp config instead
+func (sink SMTPSink) DefaultURL() string {
+	return ""
+}
+
+// check implement of configuredSink
+func (sink SMTPSink) check(filter *MsgFilterConfig) error {
+	if filter.SMTP == nil {
+		return fmt.Errorf("smtp of smtp sink is required")
+	}
+
+	if len(filter.Recipients) == 0 {
+		return fmt.Errorf("recipients of smtp sink are required")
+	}
+
+	_, err := mail.ParseAddressList(strings.Join(filter.Recipients, ","))
+	if err != nil {
+		return fmt.Errorf("recipients are invalid: %v", err)
+	}
+
+	return nil
+}
+
+// rateKey implement of endpointSink, the mail server takes the rate limit
+func (sink SMTPSink) rateKey(filter *MsgFilterConfig) string {
+	return "smtp://" + filter.SMTP.Address
+}
+
+// Render implement of Sink, every schema goes as the mail of text and html templates
+func (sink SMTPSink) Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo,
+	data TemplateData) ([][]byte, error) {
+	templates := filter.templates
+	subject := strings.TrimSpace(templates.renderTitle(data))
+	return renderMail(filter, subject, templates.renderText(data), templates.renderHTML(data))
+}
+
+// RenderText implement of Sink, the first line is the subject
+func (sink SMTPSink) RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error) {
+	subject := strings.TrimSpace(strings.SplitN(strings.TrimSpace(content), "\n", 2)[0])
+	return renderMail(filter, subject, content, "<pre>"+html.EscapeString(content)+"</pre>\n")
+}
+
+// renderMail mail of multipart/alternative, plain text goes first as the fallback
+func renderMail(filter *MsgFilterConfig, subject, text, htmlText string) ([][]byte, error) {
+	recipients, err := mail.ParseAddressList(strings.Join(filter.Recipients, ","))
+	if err != nil {
+		return nil, err
+	}
+	to := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		to = append(to, recipient.String())
+	}
+
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	headers := []struct {
+		name  string
+		value string
+	}{
+		{"From", filter.SMTP.from.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", truncateRunes(200, subject))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%d.%d@%s>", time.Now().UnixNano(), os.Getpid(), hostname)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header.name, header.value)
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", htmlText},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = parts.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{buf.Bytes()}, nil
+}
+
+// smtpError rejections(5xx) are permanent, others like 4xx or network errors are retryable
+func smtpError(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return &permanentError{err}
+	}
+
+	return err
+}
+
+// dialSMTP connect the mail server, say hello and upgrade to tls if required, the deadline of ctx covers the session
+func dialSMTP(ctx context.Context, config *SMTPConfig) (*smtp.Client, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSMTPTimeout)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         config.host,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(deadline)
+	if config.TLS == SMTPTLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		err = tlsConn.Handshake()
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, config.host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if config.TLS == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, &permanentError{fmt.Errorf("smtp server %s does not support STARTTLS", config.Address)}
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// Send implement of Sink, mail the recipients of the filter config once
+func (sink SMTPSink) Send(ctx context.Context, filter *MsgFilterConfig, body []byte, tokenSecret TokenSecret) error {
+	config := filter.SMTP
+	recipients, err := mail.ParseAddressList(strings.Join(filter.Recipients, ","))
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	smtpClient, err := dialSMTP(ctx, config)
+	if err != nil {
+		return smtpError(err)
+	}
+	defer func() { _ = smtpClient.Close() }()
+
+	if config.Username != "" {
+		err = smtpClient.Auth(smtp.PlainAuth("", config.Username, config.Password, config.host))
+		if err != nil {
+			return smtpError(err)
+		}
+	}
+
+	err = smtpClient.Mail(config.from.Address)
+	if err != nil {
+		return smtpError(err)
+	}
+	for _, recipient := range recipients {
+		err = smtpClient.Rcpt(recipient.Address)
+		if err != nil {
+			return smtpError(err)
+		}
+	}
+
+	w, err := smtpClient.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		return smtpError(err)
+	}
+	err = w.Close()
+	if err != nil {
+		return smtpError(err)
+	}
+
+	return smtpError(smtpClient.Quit())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer in-process smtp server which records one session, rcptReply answers RCPT TO
+type fakeSMTPServer struct {
+	listener  net.Listener
+	rcptReply string
+	silent    bool // accept but never greet
+
+	mutex sync.Mutex
+	auth  string
+	from  string
+	rcpts []string
+	data  string
+	done  chan struct{}
+}
+
+func newFakeSMTPServer(t *testing.T, rcptReply string, silent bool) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSMTPServer{listener: listener, rcptReply: rcptReply, silent: silent, done: make(chan struct{})}
+	go server.serve()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		<-server.done
+	})
+
+	return server
+}
+
+func (server *fakeSMTPServer) serve() {
+	defer close(server.done)
+
+	conn, err := server.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	if server.silent {
+		_, _ = conn.Read(make([]byte, 1))
+		return
+	}
+
+	text := textproto.NewConn(conn)
+	reply := func(line string) {
+		_ = text.PrintfLine("%s", line)
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		server.mutex.Lock()
+		switch command {
+		case "EHLO":
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			fields := strings.Fields(line)
+			auth, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			server.auth = string(auth)
+			reply("235 ok")
+		case "MAIL":
+			server.from = line
+			reply("250 ok")
+		case "RCPT":
+			server.rcpts = append(server.rcpts, line)
+			reply(server.rcptReply)
+		case "DATA":
+			reply("354 go ahead")
+			lines, _ := text.ReadDotLines()
+			server.data = strings.Join(lines, "\n")
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			server.mutex.Unlock()
+			return
+		default:
+			reply("502 not implemented")
+		}
+		server.mutex.Unlock()
+	}
+}
+
+func newSMTPFilter(t *testing.T, address string) *MsgFilterConfig {
+	filter := newNsqToDingDingConfig().Filter
+	filter.Sink = SinkSMTP
+	filter.SMTP = &SMTPConfig{
+		Address:  address,
+		TLS:      SMTPNoTLS,
+		Username: "alarm",
+		Password: "secret",
+		From:     "Alarm <alarm@example.com>",
+	}
+	filter.Recipients = []string{"Ops <ops@example.com>", "dev@example.com"}
+	if err := filter.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	return filter
+}
+
+func TestSMTPSinkSend(t *testing.T) {
+	tests := []struct {
+		name      string
+		rcptReply string
+		silent    bool
+		ok        bool
+		retryable bool
+	}{
+		{"sent", "250 ok", false, true, false},
+		{"rejected", "550 no such user", false, false, false},
+		{"greylisted", "451 try again later", false, false, true},
+		{"timeout", "", true, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeSMTPServer(t, test.rcptReply, test.silent)
+			filter := newSMTPFilter(t, server.listener.Addr().String())
+
+			data := sampleTemplateData()
+			bodies, err := filter.sink().Render(newMsgStyle(filter, SchemaMarkdown, nil), filter, data.LogDataInfo, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err = filter.sink().Send(ctx, filter, bodies[0], TokenSecret{})
+			if test.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if err == nil {
+					t.Fatal("want error")
+				}
+				if isRetryable(err) != test.retryable {
+					t.Fatalf("retryable of %v is %v, want %v", err, isRetryable(err), test.retryable)
+				}
+				return
+			}
+
+			_ = server.listener.Close()
+			<-server.done
+			server.mutex.Lock()
+			defer server.mutex.Unlock()
+			if server.auth != "\x00alarm\x00secret" {
+				t.Errorf("auth %q", server.auth)
+			}
+			if server.from != "MAIL FROM:<alarm@example.com>" {
+				t.Errorf("from %q", server.from)
+			}
+			if len(server.rcpts) != 2 || server.rcpts[0] != "RCPT TO:<ops@example.com>" ||
+				server.rcpts[1] != "RCPT TO:<dev@example.com>" {
+				t.Errorf("rcpts %q", server.rcpts)
+			}
+			for _, want := range []string{"Subject: ", "To: \"Ops\" <ops@example.com>, <dev@example.com>",
+				"Content-Type: text/plain; charset=utf-8", "Content-Type: text/html; charset=utf-8"} {
+				if !strings.Contains(server.data, want) {
+					t.Errorf("data has no %s:\n%s", want, server.data)
+				}
+			}
+		})
+	}
+}
+
+func TestSMTPSinkRateKey(t *testing.T) {
+	filter := newSMTPFilter(t, "127.0.0.1:25")
+	robots := filter.robots()
+	if len(robots) != 1 || robots[0] != (TokenSecret{}) {
+		t.Errorf("robots of smtp sink %v, want one zero robot", robots)
+	}
+	if key := filter.rateKey(robots[0]); key != "smtp://127.0.0.1:25" {
+		t.Errorf("rate key %s", key)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThresholdConfig logs matching the rule alarm only when count of them with the same fields
+// reaches count within window, counting starts over once it fires
+type ThresholdConfig struct {
+	Count  int      `json:"count"`
+	Window Duration `json:"window"`
+	Fields []string `json:"fields"` // fields making up counter key, all matched logs count together if empty
+}
+
+func (config *ThresholdConfig) compile() error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Count <= 0 {
+		return fmt.Errorf("threshold count should be positive")
+	}
+	if config.Window <= 0 {
+		return fmt.Errorf("threshold window should be positive")
+	}
+
+	return nil
+}
+
+// Thresholder sliding window counters of threshold rules
+type Thresholder struct {
+	mutex     sync.Mutex
+	counters  map[string][]time.Time // times of matched logs within window
+	lastSweep time.Time
+	maxWindow time.Duration
+}
+
+// NewThresholder create Thresholder
+func NewThresholder() *Thresholder {
+	return &Thresholder{
+		counters:  make(map[string][]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// expire drop times out of window
+func expire(times []time.Time, window time.Duration, now time.Time) []time.Time {
+	start := 0
+	for start < len(times) && now.Sub(times[start]) > window {
+		start++
+	}
+
+	return times[start:]
+}
+
+// count count the log of the rule by the route, return whether the threshold is reached
+// and how many logs are counted within window
+func (thresholder *Thresholder) count(rule *RuleConfig, route string, logData LogDataInfo, now time.Time) (bool, int) {
+	config := rule.Threshold
+	window := time.Duration(config.Window)
+
+	values := make([]string, 0, len(config.Fields)+2)
+	values = append(values, route, rule.Name)
+	for _, field := range config.Fields {
+		values = append(values, logData.Fields[field])
+	}
+	key := strings.Join(values, "\x00")
+
+	thresholder.mutex.Lock()
+	defer thresholder.mutex.Unlock()
+
+	if window > thresholder.maxWindow {
+		thresholder.maxWindow = window
+	}
+	thresholder.sweep(now)
+
+	times := append(expire(thresholder.counters[key], window, now), now)
+	count := len(times)
+	if count >= config.Count {
+		delete(thresholder.counters, key)
+		return true, count
+	}
+
+	thresholder.counters[key] = times
+	return false, count
+}
+
+// sweep drop counters without any log in the largest window, at most once a window
+func (thresholder *Thresholder) sweep(now time.Time) {
+	if now.Sub(thresholder.lastSweep) < thresholder.maxWindow {
+		return
+	}
+
+	for key, times := range thresholder.counters {
+		if len(expire(times, thresholder.maxWindow, now)) == 0 {
+			delete(thresholder.counters, key)
+		}
+	}
+	thresholder.lastSweep = now
+}
+
+// formatRate observed rate of count logs within window
+func formatRate(count int, window time.Duration) string {
+	return fmt.Sprintf("%s 内 %d 次 (%.1f 次/分钟)", window, count, float64(count)/window.Minutes())
+}
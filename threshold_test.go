@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholderCount(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+	rule := &RuleConfig{Name: "nil", Threshold: &ThresholdConfig{Count: 3, Window: Duration(time.Minute),
+		Fields: []string{FieldNodeName}}}
+	logOf := func(node string) LogDataInfo {
+		return LogDataInfo{Fields: map[string]string{FieldNodeName: node}}
+	}
+
+	type count struct {
+		route   string
+		node    string
+		at      time.Duration // since start
+		reached bool
+		count   int
+	}
+	tests := []struct {
+		name   string
+		counts []count
+	}{
+		{"reached", []count{{"r", "a", 0, false, 1}, {"r", "a", time.Second, false, 2},
+			{"r", "a", 2 * time.Second, true, 3}}},
+		{"start over after reached", []count{{"r", "a", 0, false, 1}, {"r", "a", 0, false, 2}, {"r", "a", 0, true, 3},
+			{"r", "a", 0, false, 1}}},
+		{"window slides", []count{{"r", "a", 0, false, 1}, {"r", "a", 30 * time.Second, false, 2},
+			{"r", "a", 61 * time.Second, false, 2}, {"r", "a", 62 * time.Second, true, 3}}},
+		{"edge of window counts", []count{{"r", "a", 0, false, 1}, {"r", "a", 0, false, 2},
+			{"r", "a", time.Minute, true, 3}}},
+		{"fields apart", []count{{"r", "a", 0, false, 1}, {"r", "b", 0, false, 1}, {"r", "a", 0, false, 2},
+			{"r", "b", 0, false, 2}}},
+		{"routes apart", []count{{"r", "a", 0, false, 1}, {"s", "a", 0, false, 1}, {"r", "a", 0, false, 2}}},
+		{"swept counters start over", []count{{"r", "a", 0, false, 1}, {"r", "b", 0, false, 1},
+			{"r", "b", 2 * time.Minute, false, 1}, {"r", "a", 2 * time.Minute, false, 1}}},
+	}
+
+	for _, test := range tests {
+		thresholder := NewThresholder()
+		thresholder.lastSweep = start
+		for i, count := range test.counts {
+			reached, n := thresholder.count(rule, count.route, logOf(count.node), start.Add(count.at))
+			if reached != count.reached || n != count.count {
+				t.Errorf("%s: count %d got %v %d, want %v %d", test.name, i+1, reached, n, count.reached, count.count)
+			}
+		}
+	}
+}
+
+func TestThresholderSweep(t *testing.T) {
+	start := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+	rule := &RuleConfig{Name: "nil", Threshold: &ThresholdConfig{Count: 10, Window: Duration(time.Minute),
+		Fields: []string{FieldNodeName}}}
+	thresholder := NewThresholder()
+	thresholder.lastSweep = start
+
+	for _, node := range []string{"a", "b", "c"} {
+		thresholder.count(rule, "r", LogDataInfo{Fields: map[string]string{FieldNodeName: node}}, start)
+	}
+	thresholder.count(rule, "r", LogDataInfo{Fields: map[string]string{FieldNodeName: "d"}}, start.Add(30*time.Second))
+	if len(thresholder.counters) != 4 {
+		t.Fatalf("%d counters before sweep, want 4", len(thresholder.counters))
+	}
+
+	thresholder.count(rule, "r", LogDataInfo{Fields: map[string]string{FieldNodeName: "e"}}, start.Add(80*time.Second))
+	if len(thresholder.counters) != 2 {
+		t.Errorf("%d counters after sweep, want those of d and e", len(thresholder.counters))
+	}
+}
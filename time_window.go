@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindowConfig alarms within the window of days and hours change how they are sent,
+// like no one is at and alarms are digested till morning at night, or go by on-call route at weekends,
+// alarms can be selected by rules and severities, all of active windows apply
+type TimeWindowConfig struct {
+	Name     string   `json:"name"`
+	Timezone string   `json:"timezone"` // like Asia/Shanghai, local if empty
+	Days     []string `json:"days"`     // like mon, sat or mon-fri, days the hours start on, every day if empty
+	Hours    []string `json:"hours"`    // like 09:00-18:00, or 22:00-08:00 through midnight, all day if empty
+
+	Rules      []string `json:"rules"`      // names of rules, one of them must be the rule matching the alarm
+	Severities []string `json:"severities"` // severities of alarms, any if empty
+
+	NotAt  bool   `json:"notAt"`  // at no one
+	Digest bool   `json:"digest"` // collect alarms and send them as one summary when the window ends
+	Route  string `json:"route"`  // name of the route which alarms go by instead of matched routes
+
+	location *time.Location
+	days     [7]bool
+	hours    [][2]int // minutes of day, end is exclusive
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWeekday(text string) (time.Weekday, error) {
+	day, ok := weekdays[strings.ToLower(strings.TrimSpace(text))]
+	if !ok {
+		return day, fmt.Errorf("unknown day %s, should be sun, mon, tue, wed, thu, fri or sat", text)
+	}
+
+	return day, nil
+}
+
+// parseClock minutes of day of 15:04, 24:00 is the end of day
+func parseClock(text string) (int, error) {
+	var hour, minute int
+	_, err := fmt.Sscanf(strings.TrimSpace(text), "%d:%d", &hour, &minute)
+	if err != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %s, should be like 08:30", text)
+	}
+
+	return hour*60 + minute, nil
+}
+
+func (window *TimeWindowConfig) compile() error {
+	if window.Name == "" {
+		return fmt.Errorf("time window name is required")
+	}
+
+	window.location = time.Local
+	if window.Timezone != "" {
+		location, err := time.LoadLocation(window.Timezone)
+		if err != nil {
+			return fmt.Errorf("timezone %s is invalid: %v", window.Timezone, err)
+		}
+		window.location = location
+	}
+
+	window.days = [7]bool{}
+	for _, text := range window.Days {
+		bounds := strings.SplitN(text, "-", 2)
+		start, err := parseWeekday(bounds[0])
+		if err != nil {
+			return err
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = parseWeekday(bounds[1])
+			if err != nil {
+				return err
+			}
+		}
+		for day := start; ; day = (day + 1) % 7 {
+			window.days[day] = true
+			if day == end {
+				break
+			}
+		}
+	}
+	if len(window.Days) == 0 {
+		window.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	window.hours = nil
+	for _, text := range window.Hours {
+		bounds := strings.SplitN(text, "-", 2)
+		if len(bounds) != 2 {
+			return fmt.Errorf("invalid hours %s, should be like 09:00-18:00", text)
+		}
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("invalid hours %s, should not be empty", text)
+		}
+		window.hours = append(window.hours, [2]int{start, end})
+	}
+
+	for _, severity := range window.Severities {
+		if severity == "" || checkSeverity(severity) != nil {
+			return fmt.Errorf("unknown severity %s, should be critical, error, warning or info", severity)
+		}
+	}
+
+	return nil
+}
+
+// contains whether the time is within the window, days are those the hours start on in timezone of the window,
+// so the part of 22:00-08:00 after midnight belongs to the day before
+func (window *TimeWindowConfig) contains(t time.Time) bool {
+	t = t.In(window.location)
+	day, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	if len(window.hours) == 0 {
+		return window.days[day]
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, hours := range window.hours {
+		start, end := hours[0], hours[1]
+		// hours through midnight end on the next day
+		today := minute >= start && (minute < end || start > end)
+		overnight := start > end && minute < end
+		if (today && window.days[day]) || (overnight && window.days[yesterday]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selects whether the window applies to the alarm of the rule and severity
+func (window *TimeWindowConfig) selects(rule *RuleConfig, severity string) bool {
+	if len(window.Rules) > 0 && (rule == nil || !containsString(window.Rules, rule.Name)) {
+		return false
+	}
+
+	return len(window.Severities) == 0 || containsString(window.Severities, severity)
+}
+
+// activeWindows time windows which apply to the alarm at the time
+func (filter *MsgFilterConfig) activeWindows(logData LogDataInfo, rule *RuleConfig, now time.Time) []*TimeWindowConfig {
+	var windows []*TimeWindowConfig
+	for _, window := range filter.TimeWindows {
+		if window.selects(rule, logData.Severity) && window.contains(now) {
+			windows = append(windows, window)
+		}
+	}
+
+	return windows
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	// 2024-01-05 is a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		days  []string
+		hours []string
+		t     time.Time
+		want  bool
+	}{
+		{"all the time", nil, nil, at(5, 3, 0), true},
+		{"weekend", []string{"sat-sun"}, nil, at(6, 12, 0), true},
+		{"not weekend", []string{"sat-sun"}, nil, at(5, 23, 59), false},
+		{"office hours", []string{"mon-fri"}, []string{"09:00-18:00"}, at(5, 9, 0), true},
+		{"office hours end", []string{"mon-fri"}, []string{"09:00-18:00"}, at(5, 18, 0), false},
+		{"office hours at weekend", []string{"mon-fri"}, []string{"09:00-18:00"}, at(6, 10, 0), false},
+		{"night before midnight", []string{"mon-fri"}, []string{"22:00-08:00"}, at(5, 23, 0), true},
+		{"night after midnight of the day before", []string{"mon-fri"}, []string{"22:00-08:00"}, at(6, 3, 0), true},
+		{"night after midnight of sunday", []string{"mon-fri"}, []string{"22:00-08:00"}, at(8, 3, 0), false},
+		{"night before midnight at weekend", []string{"mon-fri"}, []string{"22:00-08:00"}, at(6, 23, 0), false},
+		{"night of sunday", []string{"sun"}, []string{"22:00-08:00"}, at(8, 7, 59), true},
+		{"night end", []string{"sun"}, []string{"22:00-08:00"}, at(8, 8, 0), false},
+		{"day end", nil, []string{"18:00-24:00"}, at(5, 23, 59), true},
+		{"several hours", nil, []string{"00:00-01:00", "12:00-13:00"}, at(5, 12, 30), true},
+		{"between hours", nil, []string{"00:00-01:00", "12:00-13:00"}, at(5, 6, 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window := &TimeWindowConfig{Name: test.name, Timezone: "UTC", Days: test.days, Hours: test.hours}
+			if err := window.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := window.contains(test.t); got != test.want {
+				t.Errorf("contains %s = %v, want %v", test.t.Format("Mon 15:04"), got, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowTimezone(t *testing.T) {
+	window := &TimeWindowConfig{Name: "night", Timezone: "Asia/Shanghai", Days: []string{"fri"},
+		Hours: []string{"22:00-08:00"}}
+	if err := window.compile(); err != nil {
+		t.Skip(err)
+	}
+
+	// saturday 02:00 in Shanghai
+	if !window.contains(time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("night of friday in Shanghai is not contained")
+	}
+}
@@ -13,6 +13,39 @@ import (
 	"github.com/nsqio/go-nsq"
 )
 
+// Duration time.Duration in config, like "5m" or number of seconds
+type Duration time.Duration
+
+// UnmarshalJSON implement of json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+
+	return nil
+}
+
+// MarshalJSON implement of json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// TokenSecret token and secret of dingding robot
 type TokenSecret struct {
 	Token  string `json:"token"`
 	Secret string `json:"secret"`
@@ -20,14 +53,248 @@ type TokenSecret struct {
 
 // MsgFilterConfig msg fileter config structure
 type MsgFilterConfig struct {
+	Sink         string        `json:"sink"` // dingding(default), feishu, wecom, slack, webhook or smtp
 	URL          string        `json:"url"`
 	Protocol     string        `json:"protocol"`
 	FilterKeys   []string      `json:"filterKeys"`
 	IgnoreKeys   []string      `json:"ignoreKeys"`
 	NotAtKeys    []string      `json:"notAtKeys"`
 	AtMobiles    []string      `json:"atMobiles"`
+	AtUserIds    []string      `json:"atUserIds"`   // user ids of the sink
+	OnCall       string        `json:"onCall"`      // name of the schedule, whose members on call are at too
+	FilterRules  []string      `json:"filterRules"` // rule expressions, see RuleExpr
+	IgnoreRules  []string      `json:"ignoreRules"`
+	NotAtRules   []string      `json:"notAtRules"`
 	Schema       string        `json:"schema"`
 	TokenSecrets []TokenSecret `json:"token-secrets"`
+	RateLimit    int           `json:"rateLimit"` // messages per minute of each robot, not positive means unlimited or the limit of the sink
+
+	MaxBodyBytes   int    `json:"maxBodyBytes"`   // max bytes of request body, content is fit into it, not positive means unlimited
+	OversizePolicy string `json:"oversizePolicy"` // truncate(default) or split content over max body bytes
+	MaxSplitParts  int    `json:"maxSplitParts"`  // content which needs more parts is truncated instead, not positive means unlimited
+
+	Fields     []FieldMappingConfig `json:"fields"`
+	Templates  *TemplateConfig      `json:"templates"`
+	ActionCard *ActionCardConfig    `json:"actionCard"`
+	Link       *LinkConfig          `json:"link"`
+	FeedCard   *FeedCardConfig      `json:"feedCard"`
+	Rules      []*RuleConfig        `json:"rules"`
+	Dedup      *DedupConfig         `json:"dedup"`
+	Digest     *DigestConfig        `json:"digest"`
+	Routes     []*RouteConfig       `json:"routes"`
+
+	SeverityField   string                     `json:"severityField"`   // field of log level, like level
+	DefaultSeverity string                     `json:"defaultSeverity"` // severity of logs without level, empty means not classified
+	Severities      map[string]*SeverityConfig `json:"severities"`      // key is critical, error, warning or info
+
+	TimeWindows []*TimeWindowConfig `json:"timeWindows"` // quiet hours, weekends and so on
+	Schedules   []*ScheduleConfig   `json:"schedules"`   // on-call schedules
+
+	SlackUsers map[string]string `json:"slackUsers"` // mobile to slack member id, slack sink at mobiles by it
+	Webhook    *WebhookConfig    `json:"webhook"`    // request of webhook sink
+	SMTP       *SMTPConfig       `json:"smtp"`       // mail server of smtp sink
+	Recipients []string          `json:"recipients"` // addresses which smtp sink mails to
+
+	routeName    string // name of the route which the config is made for, empty for the default
+	fieldMapping *FieldMapping
+	templates    *MsgTemplates
+	filterRules  []*RuleExpr
+	ignoreRules  []*RuleExpr
+	notAtRules   []*RuleExpr
+	severities   map[string]*SeverityConfig
+	schedules    map[string]*ScheduleConfig
+}
+
+// compile check the config and compile what it needs to deal messages, called once the config is loaded
+func (filter *MsgFilterConfig) compile() error {
+	var err error
+	err = checkSink(filter.Sink)
+	if err != nil {
+		return err
+	}
+	if filter.URL == "" {
+		filter.URL = filter.sink().DefaultURL()
+	}
+	if filter.Webhook != nil {
+		err = filter.Webhook.compile()
+		if err != nil {
+			return fmt.Errorf("webhook is invalid: %v", err)
+		}
+	}
+	if filter.SMTP != nil {
+		err = filter.SMTP.compile()
+		if err != nil {
+			return fmt.Errorf("smtp is invalid: %v", err)
+		}
+	}
+	err = checkSinkConfig(filter)
+	if err != nil {
+		return err
+	}
+
+	filter.fieldMapping, err = compileFieldMapping(filter.Fields)
+	if err != nil {
+		return fmt.Errorf("fields is invalid: %v", err)
+	}
+
+	filter.filterRules, err = compileRuleExprs("filterRules", filter.FilterRules)
+	if err != nil {
+		return err
+	}
+	filter.ignoreRules, err = compileRuleExprs("ignoreRules", filter.IgnoreRules)
+	if err != nil {
+		return err
+	}
+	filter.notAtRules, err = compileRuleExprs("notAtRules", filter.NotAtRules)
+	if err != nil {
+		return err
+	}
+
+	filter.templates, err = compileMsgTemplates(filter.Templates)
+	if err != nil {
+		return fmt.Errorf("templates is invalid: %v", err)
+	}
+
+	err = checkSchema(filter.Schema)
+	if err != nil {
+		return err
+	}
+
+	err = checkOversizePolicy(filter.OversizePolicy)
+	if err != nil {
+		return err
+	}
+
+	err = filter.Dedup.compile()
+	if err != nil {
+		return err
+	}
+
+	err = filter.Digest.compile()
+	if err != nil {
+		return err
+	}
+
+	filter.schedules, err = compileSchedules(filter.Schedules)
+	if err != nil {
+		return err
+	}
+	err = filter.checkOnCall(filter.OnCall)
+	if err != nil {
+		return err
+	}
+
+	err = checkSeverity(filter.DefaultSeverity)
+	if err != nil {
+		return err
+	}
+	filter.severities, err = compileSeverities(filter.Severities)
+	if err != nil {
+		return err
+	}
+	for severity, config := range filter.severities {
+		err = filter.checkOnCall(config.OnCall)
+		if err != nil {
+			return fmt.Errorf("severity %s is invalid: %v", severity, err)
+		}
+	}
+
+	sample := sampleTemplateData()
+	err = filter.ActionCard.compile(sample)
+	if err != nil {
+		return err
+	}
+	err = filter.Link.compile(sample)
+	if err != nil {
+		return err
+	}
+	err = filter.FeedCard.compile(sample)
+	if err != nil {
+		return err
+	}
+
+	schema := filter.Schema
+	if schema == "" {
+		schema = SchemaText
+	}
+	err = newMsgStyle(filter, schema, nil).check()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range filter.Rules {
+		err = rule.compile(sample)
+		if err != nil {
+			return fmt.Errorf("rule %s is invalid: %v", rule.Name, err)
+		}
+
+		err = newMsgStyle(filter, schema, rule).check()
+		if err != nil {
+			return fmt.Errorf("rule %s is invalid: %v", rule.Name, err)
+		}
+
+		err = filter.checkOnCall(rule.OnCall)
+		if err != nil {
+			return fmt.Errorf("rule %s is invalid: %v", rule.Name, err)
+		}
+	}
+
+	routeNames := make(map[string]bool)
+	for _, route := range filter.Routes {
+		err = route.compile(filter)
+		if err != nil {
+			return fmt.Errorf("route %s is invalid: %v", route.Name, err)
+		}
+
+		if routeNames[route.Name] {
+			return fmt.Errorf("route %s is duplicated", route.Name)
+		}
+		routeNames[route.Name] = true
+
+		routeSchema := route.filter.Schema
+		if routeSchema == "" {
+			routeSchema = schema
+		}
+		for _, rule := range append([]*RuleConfig{nil}, filter.Rules...) {
+			err = newMsgStyle(route.filter, routeSchema, rule).check()
+			if err != nil {
+				return fmt.Errorf("route %s is invalid: %v", route.Name, err)
+			}
+		}
+	}
+
+	for severity, config := range filter.severities {
+		if config.Route != "" && !routeNames[config.Route] {
+			return fmt.Errorf("route %s of severity %s is not found", config.Route, severity)
+		}
+	}
+
+	for _, window := range filter.TimeWindows {
+		err = window.compile()
+		if err != nil {
+			return fmt.Errorf("time window %s is invalid: %v", window.Name, err)
+		}
+
+		if window.Route != "" && !routeNames[window.Route] {
+			return fmt.Errorf("route %s of time window %s is not found", window.Route, window.Name)
+		}
+		for _, name := range window.Rules {
+			if filter.rule(name) == nil {
+				return fmt.Errorf("rule %s of time window %s is not found", name, window.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// compile check the config and compile its filter
+func (config *NsqToDingDingConfig) compile() error {
+	if config.Filter == nil {
+		return fmt.Errorf("filter is required")
+	}
+
+	return config.Filter.compile()
 }
 
 // NsqToDingDingConfig config structure
@@ -55,6 +322,20 @@ type TopicDiscoverer struct {
 	etcdCli       *clientv3.Client
 	config        *NsqToDingDingConfig
 	watcher       clientv3.Watcher
+	limiter       *RobotRateLimiter
+	deadLetter    *DeadLetterProducer
+	silences      *SilenceStore
+	admin         *AdminServer
+}
+
+// newEtcdClient create etcd client
+func newEtcdClient(etcdEndpoints []string, etcdUsername, etcdPassword string) (*clientv3.Client, error) {
+	return clientv3.New(clientv3.Config{
+		Endpoints:   etcdEndpoints,
+		DialTimeout: 5 * time.Second,
+		Username:    etcdUsername,
+		Password:    etcdPassword,
+	})
 }
 
 func newTopicDiscoverer(opts *Options, cfg *nsq.Config, hupChan chan os.Signal, termChan chan os.Signal,
@@ -70,19 +351,16 @@ func newTopicDiscoverer(opts *Options, cfg *nsq.Config, hupChan chan os.Signal,
 		etcdUsername:  etcdUsername,
 		etcdPassword:  etcdPassword,
 		etcdPath:      etcdPath,
+		limiter:       NewRobotRateLimiter(),
 	}
 
-	etcdCli, err := clientv3.New(clientv3.Config{
-		Endpoints:   etcdEndpoints,
-		DialTimeout: 5 * time.Second,
-		Username:    etcdUsername,
-		Password:    etcdPassword,
-	})
+	etcdCli, err := newEtcdClient(etcdEndpoints, etcdUsername, etcdPassword)
 	if err != nil {
 		return nil, err
 	}
 
 	discoverer.etcdCli = etcdCli
+	discoverer.silences = NewSilenceStore(etcdCli, etcdPath)
 
 	return discoverer, nil
 }
@@ -93,7 +371,8 @@ func (discoverer *TopicDiscoverer) updateTopics(topics []string) {
 			continue
 		}
 
-		nsqConsumer, err := NewNSQConsumer(discoverer.opts, topic, discoverer.cfg, discoverer.config)
+		nsqConsumer, err := NewNSQConsumer(discoverer.opts, topic, discoverer.cfg, discoverer.config,
+			discoverer.limiter, discoverer.deadLetter, discoverer.silences)
 		if err != nil {
 			discoverer.logger.Printf("error: could not register topic %s: %s", topic, err)
 			continue
@@ -118,8 +397,10 @@ func newNsqToDingDingConfig() *NsqToDingDingConfig {
 	config := &NsqToDingDingConfig{
 		TopicRefreshInterval: 30,
 		Filter: &MsgFilterConfig{
-			Protocol: "https",
-			URL:      "oapi.dingtalk.com/robot/send",
+			Protocol:      "https",
+			RateLimit:     20,
+			MaxBodyBytes:  20000,
+			MaxSplitParts: 5,
 		},
 	}
 
@@ -140,6 +421,12 @@ func (discoverer *TopicDiscoverer) watchConfig(watchStartVer int64) {
 					break
 				}
 
+				err = config.compile()
+				if err != nil {
+					discoverer.logger.Printf("配置错误: %v %s", err, string(ev.Kv.Value))
+					break
+				}
+
 				// todo: 检查配置格式
 				discoverer.config = config
 
@@ -195,6 +482,11 @@ func (discoverer *TopicDiscoverer) initAndWatchConfig() error {
 		return fmt.Errorf("Config is invalid, topic is required")
 	}
 
+	err = config.compile()
+	if err != nil {
+		return fmt.Errorf("Config is invalid, %v", err)
+	}
+
 	discoverer.config = config
 
 	fmt.Println("init config", config)
@@ -211,6 +503,27 @@ func (discoverer *TopicDiscoverer) run() error {
 		return err
 	}
 
+	if discoverer.opts.DeadLetterTopic != "" {
+		discoverer.deadLetter, err = NewDeadLetterProducer(discoverer.opts, discoverer.cfg, discoverer.config)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = discoverer.silences.start()
+	if err != nil {
+		return fmt.Errorf("load silences fail: %v", err)
+	}
+
+	if discoverer.opts.HTTPAddress != "" {
+		discoverer.admin = NewAdminServer(discoverer.opts.HTTPAddress, discoverer.opts.HTTPToken,
+			discoverer.silences)
+		err = discoverer.admin.start()
+		if err != nil {
+			return err
+		}
+	}
+
 	ticker := time.Tick(discoverer.config.TopicRefreshInterval * time.Second)
 	discoverer.updateTopics(discoverer.config.Topics)
 
@@ -222,6 +535,8 @@ forloop:
 		case <-discoverer.termChan:
 			discoverer.watcher.Close()
 			discoverer.wg.Done()
+			discoverer.silences.Close()
+			discoverer.admin.Close()
 
 			discoverer.etcdCli.Close()
 
@@ -232,6 +547,8 @@ forloop:
 		case <-discoverer.hupChan:
 			discoverer.watcher.Close()
 			discoverer.wg.Done()
+			discoverer.silences.Close()
+			discoverer.admin.Close()
 
 			discoverer.etcdCli.Close()
 
@@ -243,6 +560,7 @@ forloop:
 	}
 
 	discoverer.wg.Wait()
+	discoverer.deadLetter.Stop()
 
 	return nil
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultWebhookBody all of the template data, like {"machineName":...,"message":...,"Topic":...}
+const defaultWebhookBody = "{{json .}}"
+
+// WebhookConfig request of webhook sink, which posts alarms to url of filter config,
+// token secrets are not used
+type WebhookConfig struct {
+	Method          string            `json:"method"`          // POST(default), PUT or PATCH
+	Headers         map[string]string `json:"headers"`         // Content-Type is application/json if not given
+	Body            string            `json:"body"`            // text/template of json body executed with TemplateData, all of it if empty
+	Secret          string            `json:"secret"`          // key of hmac-sha256 of the body, not signed if empty
+	SignatureHeader string            `json:"signatureHeader"` // header of the signature like sha256=<hex>, X-Signature if empty
+
+	body *template.Template
+}
+
+var defaultWebhookConfig = &WebhookConfig{
+	Method:          http.MethodPost,
+	SignatureHeader: "X-Signature",
+	body:            template.Must(parseMsgTemplate("webhook", defaultWebhookBody)),
+}
+
+func (config *WebhookConfig) compile() error {
+	switch strings.ToUpper(config.Method) {
+	case "":
+		config.Method = defaultWebhookConfig.Method
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		config.Method = strings.ToUpper(config.Method)
+	default:
+		return fmt.Errorf("unknown method %s, should be POST, PUT or PATCH", config.Method)
+	}
+
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = defaultWebhookConfig.SignatureHeader
+	}
+
+	text := config.Body
+	if text == "" {
+		text = defaultWebhookBody
+	}
+	sample := sampleTemplateData()
+	tmpl, err := compileCheckedTemplate("webhook", text, sample)
+	if err != nil {
+		return err
+	}
+	if !json.Valid([]byte(renderTemplate(tmpl, sample))) {
+		return fmt.Errorf("body is not json for sample data, values may need {{json .Msg}}")
+	}
+	config.body = tmpl
+
+	return nil
+}
+
+// WebhookSink sink of any http endpoint like ticketing systems or bots of our own,
+// the body is rendered by the template of webhook config and msgs are never split
+type WebhookSink struct{}
+
+// DefaultURL implement of Sink, webhook url must be configured
+func (sink WebhookSink) DefaultURL() string {
+	return ""
+}
+
+// check implement of configuredSink
+func (sink WebhookSink) check(filter *MsgFilterConfig) error {
+	if filter.URL == "" {
+		return fmt.Errorf("url of webhook sink is required")
+	}
+
+	return nil
+}
+
+// rateKey implement of endpointSink, the url takes the rate limit
+func (sink WebhookSink) rateKey(filter *MsgFilterConfig) string {
+	return fmt.Sprintf("%s://%s", filter.Protocol, filter.URL)
+}
+
+func (sink WebhookSink) config(filter *MsgFilterConfig) *WebhookConfig {
+	if filter.Webhook == nil {
+		return defaultWebhookConfig
+	}
+
+	return filter.Webhook
+}
+
+// Render implement of Sink, the body template takes the place of schemas
+func (sink WebhookSink) Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo,
+	data TemplateData) ([][]byte, error) {
+	data.IsAtAll = logData.IsAtAll
+	data.AtMobiles = logData.AtMobiles
+	data.AtUserIds = logData.AtUserIds
+
+	return fitBodies(data.Msg, filter.MaxBodyBytes, OversizeTruncate, 0, func(parts []string) ([][]byte, error) {
+		data.Msg = parts[0]
+		var buf bytes.Buffer
+		err := sink.config(filter).body.Execute(&buf, data)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(buf.Bytes()) {
+			return nil, fmt.Errorf("webhook body is not json: %s", buf.String())
+		}
+		return [][]byte{buf.Bytes()}, nil
+	})
+}
+
+// RenderText implement of Sink, the content goes as .Msg of the body
+func (sink WebhookSink) RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error) {
+	return sink.renderSourceText(filter, logData, content, TemplateData{Time: time.Now()})
+}
+
+// renderSourceText implement of sourceTextSink, .Topic and .RawJSON of the body are those of the source message
+func (sink WebhookSink) renderSourceText(filter *MsgFilterConfig, logData LogDataInfo, content string,
+	source TemplateData) ([][]byte, error) {
+	logData.Msg = content
+	data := source
+	data.LogDataInfo = logData
+	data.Marker = filter.marker(logData.Severity)
+	return sink.Render(msgStyle{schema: SchemaText}, filter, logData, data)
+}
+
+// sign hex of hmac-sha256 of the body
+func (config *WebhookConfig) sign(body []byte) string {
+	h := hmac.New(sha256.New, []byte(config.Secret))
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Send implement of Sink, headers of webhook config go with the request, any 2xx is success
+func (sink WebhookSink) Send(ctx context.Context, filter *MsgFilterConfig, reqBodyJSON []byte,
+	tokenSecret TokenSecret) error {
+	config := sink.config(filter)
+	headers := make(map[string]string, len(config.Headers)+1)
+	for name, value := range config.Headers {
+		headers[name] = value
+	}
+	if config.Secret != "" {
+		headers[config.SignatureHeader] = "sha256=" + config.sign(reqBodyJSON)
+	}
+
+	_, err := postJSON(ctx, SinkWebhook, config.Method, fmt.Sprintf("%s://%s", filter.Protocol, filter.URL), headers,
+		reqBodyJSON)
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWebhookConfigCompile(t *testing.T) {
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{"", true},
+		{`{"text":{{json .Msg}}}`, true},
+		{`{"text":{{json .Msg}},"node":{{json .NodeName}},"at":{{json .AtMobiles}}}`, true},
+		{`{"text":"{{.Msg}}"}`, false},
+		{`{"text":"{{.Msg}}`, false},
+		{`{"text":{{.Msg}}}`, false},
+	}
+
+	for _, test := range tests {
+		config := &WebhookConfig{Body: test.body}
+		err := config.compile()
+		if test.ok && err != nil {
+			t.Errorf("body %s: %v", test.body, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("body %s: want error", test.body)
+		}
+	}
+}
+
+func TestWebhookRenderMsg(t *testing.T) {
+	filter := newNsqToDingDingConfig().Filter
+	filter.Sink = SinkWebhook
+	filter.URL = "example.com/alarm"
+	filter.Webhook = &WebhookConfig{Body: `{"text":{{json .Msg}}}`}
+	if err := filter.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := sampleTemplateData()
+	bodies, err := WebhookSink{}.Render(newMsgStyle(filter, SchemaText, nil), filter, data.LogDataInfo, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(bodies[0], &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Text != data.Msg {
+		t.Errorf("got text %q, want %q", req.Text, data.Msg)
+	}
+}
+
+func TestWebhookRenderSourceText(t *testing.T) {
+	filter := newNsqToDingDingConfig().Filter
+	filter.Sink = SinkWebhook
+	filter.URL = "example.com/alarm"
+	filter.Webhook = &WebhookConfig{Body: `{"topic":{{json .Topic}},"raw":{{json .RawJSON}},"text":{{json .Msg}}}`}
+	if err := filter.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	source := TemplateData{Topic: "game_log", RawJSON: `{"message":"error"}`}
+	bodies, err := filter.renderText(sampleTemplateData().LogDataInfo, "repeated 3 times", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req struct {
+		Topic string `json:"topic"`
+		Raw   string `json:"raw"`
+		Text  string `json:"text"`
+	}
+	if err := json.Unmarshal(bodies[0], &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Topic != source.Topic || req.Raw != source.RawJSON || req.Text != "repeated 3 times" {
+		t.Errorf("got %+v, want topic and raw json of the source", req)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// limits of wecom group robots
+const (
+	wecomMaxTextBytes     = 2048
+	wecomMaxMarkdownBytes = 4096
+	wecomRateLimit        = 20
+)
+
+// WeComReqText wecom req text content structure, @all in the lists at everyone
+type WeComReqText struct {
+	Content             string   `json:"content"`
+	MentionedList       []string `json:"mentioned_list,omitempty"`
+	MentionedMobileList []string `json:"mentioned_mobile_list,omitempty"`
+}
+
+// WeComReqMarkdown wecom req markdown content structure
+type WeComReqMarkdown struct {
+	Content string `json:"content"`
+}
+
+// WeComReqBodyInfo wecom req body structure
+type WeComReqBodyInfo struct {
+	MsgType  string            `json:"msgtype"`
+	Text     *WeComReqText     `json:"text,omitempty"`
+	Markdown *WeComReqMarkdown `json:"markdown,omitempty"`
+}
+
+// WeComSink sink of wecom group robots, token is the key of webhook url and secret is not used
+type WeComSink struct{}
+
+// DefaultURL implement of Sink
+func (sink WeComSink) DefaultURL() string {
+	return "qyapi.weixin.qq.com/cgi-bin/webhook/send"
+}
+
+// MaxRateLimit implement of rateLimitSink, wecom robots take 20 messages per minute
+func (sink WeComSink) MaxRateLimit() int {
+	return wecomRateLimit
+}
+
+// wecomMaxBytes max bytes of content, no more than the limit of wecom
+func wecomMaxBytes(filter *MsgFilterConfig, limit int) int {
+	if filter.MaxBodyBytes <= 0 || filter.MaxBodyBytes > limit {
+		return limit
+	}
+
+	return filter.MaxBodyBytes
+}
+
+// wecomMentions mentioned lists of text msg
+func wecomMentions(logData LogDataInfo) ([]string, []string) {
+	userIds := append([]string(nil), logData.AtUserIds...)
+	mobiles := append([]string(nil), logData.AtMobiles...)
+	if logData.IsAtAll {
+		mobiles = append(mobiles, "@all")
+	}
+
+	return userIds, mobiles
+}
+
+func generateWeComTextBody(logData LogDataInfo, content string) ([]byte, error) {
+	userIds, mobiles := wecomMentions(logData)
+	return json.Marshal(WeComReqBodyInfo{
+		MsgType: "text",
+		Text: &WeComReqText{
+			Content:             content,
+			MentionedList:       userIds,
+			MentionedMobileList: mobiles,
+		},
+	})
+}
+
+func generateWeComMarkdownBody(content string) ([]byte, error) {
+	return json.Marshal(WeComReqBodyInfo{
+		MsgType:  "markdown",
+		Markdown: &WeComReqMarkdown{Content: content},
+	})
+}
+
+// Render implement of Sink, text schema goes as text msg and others as markdown, buttons of dingding
+// cards become links, markdown can only at user ids, so a text msg which ats the others follows,
+// user ids go with it too when their at tokens do not fit in markdown
+func (sink WeComSink) Render(style msgStyle, filter *MsgFilterConfig, logData LogDataInfo,
+	data TemplateData) ([][]byte, error) {
+	templates := filter.templates
+	if style.schema == SchemaText {
+		return sink.RenderText(filter, logData, templates.renderText(data))
+	}
+
+	content := templates.renderMarkdown(data)
+	for _, link := range styleLinks(style, data) {
+		content += fmt.Sprintf("\n[%s](%s)", link.title, link.url)
+	}
+
+	var mention string
+	for _, userID := range logData.AtUserIds {
+		mention += fmt.Sprintf("<@%s>", userID)
+	}
+
+	// mobiles and @all can only be at by a text msg which follows
+	at := logData.withoutMention()
+	at.IsAtAll, at.AtMobiles = logData.IsAtAll, logData.AtMobiles
+
+	maxBytes := wecomMaxBytes(filter, wecomMaxMarkdownBytes)
+	if mention != "" && maxBytes-len(mention)-1 >= minContentBudget {
+		maxBytes -= len(mention) + 1
+	} else if mention != "" {
+		// too many user ids to at in markdown, the text msg ats them by mentioned list
+		mention = ""
+		at.AtUserIds = logData.AtUserIds
+	}
+
+	var bodies [][]byte
+	for _, part := range fitContent(content, maxBytes, filter.OversizePolicy, filter.MaxSplitParts) {
+		if mention != "" {
+			part += "\n" + mention
+		}
+		body, err := generateWeComMarkdownBody(part)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	if at.IsAtAll || len(at.AtMobiles) > 0 || len(at.AtUserIds) > 0 {
+		title := strings.TrimSpace(templates.renderTitle(data))
+		body, err := generateWeComTextBody(at, fitContent(title, wecomMaxTextBytes, OversizeTruncate, 0)[0])
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	return bodies, nil
+}
+
+// RenderText implement of Sink, mentions go in the mentioned lists rather than the content
+func (sink WeComSink) RenderText(filter *MsgFilterConfig, logData LogDataInfo, content string) ([][]byte, error) {
+	var bodies [][]byte
+	for _, part := range fitContent(content, wecomMaxBytes(filter, wecomMaxTextBytes), filter.OversizePolicy, filter.MaxSplitParts) {
+		body, err := generateWeComTextBody(logData, part)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	return bodies, nil
+}
+
+// Send implement of Sink, the token goes as key of the url and nothing is signed
+func (sink WeComSink) Send(ctx context.Context, filter *MsgFilterConfig, reqBodyJSON []byte,
+	tokenSecret TokenSecret) error {
+	respBody, err := postJSON(ctx, SinkWeCom, http.MethodPost, fmt.Sprintf("%s://%s?key=%s", filter.Protocol, filter.URL,
+		url.QueryEscape(tokenSecret.Token)), nil, reqBodyJSON)
+	if err != nil {
+		return err
+	}
+
+	return checkErrCode(SinkWeCom, respBody)
+}
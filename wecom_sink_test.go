@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWeComRenderMentions(t *testing.T) {
+	var manyUsers []string
+	for i := 0; i < 400; i++ {
+		manyUsers = append(manyUsers, fmt.Sprintf("user%03d", i))
+	}
+
+	tests := []struct {
+		name         string
+		userIds      []string
+		mobiles      []string
+		markdownAt   bool // user ids are at in markdown
+		textAtUsers  int  // user ids in mentioned list of the text msg
+		textFollowed bool
+	}{
+		{"nobody", nil, nil, false, 0, false},
+		{"few users", []string{"alice", "bob"}, nil, true, 0, false},
+		{"mobiles", nil, []string{"13800000000"}, false, 0, true},
+		{"too many users", manyUsers, nil, false, len(manyUsers), true},
+	}
+
+	for _, test := range tests {
+		for _, policy := range []string{OversizeTruncate, OversizeSplit} {
+			t.Run(test.name+" "+policy, func(t *testing.T) {
+				filter := newNsqToDingDingConfig().Filter
+				filter.Sink = SinkWeCom
+				filter.OversizePolicy = policy
+				if err := filter.compile(); err != nil {
+					t.Fatal(err)
+				}
+
+				data := sampleTemplateData()
+				data.Msg = strings.Repeat("stack traceback: in function <foo.lua:12>\n", 500)
+				logData := data.LogDataInfo
+				logData.AtUserIds, logData.AtMobiles = test.userIds, test.mobiles
+				bodies, err := WeComSink{}.Render(newMsgStyle(filter, SchemaMarkdown, nil), filter, logData, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var text *WeComReqText
+				for i, body := range bodies {
+					var req WeComReqBodyInfo
+					if err := json.Unmarshal(body, &req); err != nil {
+						t.Fatal(err)
+					}
+					if req.Text != nil {
+						if i != len(bodies)-1 {
+							t.Errorf("text msg is body %d of %d", i+1, len(bodies))
+						}
+						text = req.Text
+						continue
+					}
+
+					content := req.Markdown.Content
+					if len(content) > wecomMaxMarkdownBytes {
+						t.Errorf("markdown %d has %d bytes", i+1, len(content))
+					}
+					if test.markdownAt != strings.Contains(content, "<@") {
+						t.Errorf("markdown %d ats users %v, want %v", i+1, !test.markdownAt, test.markdownAt)
+					}
+				}
+
+				if (text != nil) != test.textFollowed {
+					t.Fatalf("text msg follows %v, want %v", text != nil, test.textFollowed)
+				}
+				if text != nil && len(text.MentionedList) != test.textAtUsers {
+					t.Errorf("text msg ats %d users, want %d", len(text.MentionedList), test.textAtUsers)
+				}
+			})
+		}
+	}
+}